@@ -0,0 +1,698 @@
+package wechat_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leptonyu/wechat"
+	"github.com/leptonyu/wechat/wechattest"
+)
+
+func newTestServer(t *testing.T) (*wechattest.Server, *wechat.WeChat) {
+	s := wechattest.NewServer("wxappid", "secret")
+	s.AddUser(wechat.User{Openid: "u1", Nickname: "Alice"})
+	wc, err := s.NewWeChat("token", wechat.WithRetryPolicy(wechat.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, wc
+}
+
+func TestRetryExpiredToken(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.ExpireToken()
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.PostText("u1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if s.TokenCount() != 2 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
+
+func TestRetrySystemBusy(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Fail(wechattest.ErrCodeSystemBusy, 502)
+	if u, err := wc.GetUser("u1", ""); err != nil || u.Nickname != "Alice" {
+		t.Error(u, err)
+	}
+	// Sending message is not retried, it may have been delivered
+	s.Fail(wechattest.ErrCodeSystemBusy)
+	if err := wc.PostText("u1", "hello"); err == nil {
+		t.Error("send retried")
+	}
+	s.Fail(502)
+	if err := wc.PostText("u1", "hello"); err == nil {
+		t.Error("send retried")
+	}
+	if len(s.Sent()) != 0 {
+		t.Error(s.Sent())
+	}
+	s.Fail(wechattest.ErrCodeSystemBusy, wechattest.ErrCodeSystemBusy, wechattest.ErrCodeSystemBusy)
+	if _, err := wc.GetUser("u1", ""); err == nil {
+		t.Error("retried more than MaxAttempts")
+	}
+}
+
+func TestTokenSingleFlight(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := wc.GetUser("u1", "")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.TokenCount() != 1 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u1"})
+	if _, err := s.NewWeChat("token", wechat.WithTokenRenewal(2*time.Hour)); err != wechat.ErrTokenRenewal {
+		t.Error(err)
+	}
+	// Token expires in 10 minutes, so it is always renewed in background
+	s.TokenExpiresIn = 600
+	wc, err := s.NewWeChat("token", wechat.WithTokenRenewal(wechat.DefaultTokenRenewal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && s.TokenCount() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.TokenCount() != 2 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
+
+func TestTokenSharedStorage(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u1"})
+	// Instances sharing storage, like replicas sharing MongoStorage
+	storage := wechat.NewMemStorage("wxappid", "secret", "token")
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 4; i++ {
+		wc, err := wechat.New(storage, wechat.WithAPIHost(s.APIHost()), wechat.WithHTTPClient(s.Client()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := wc.GetUser("u1", "")
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.TokenCount() != 1 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
+
+func TestTokenServer(t *testing.T) {
+	s, central := newTestServer(t)
+	defer s.Close()
+	ts := httptest.NewServer(central.TokenHandler("key"))
+	defer ts.Close()
+	// Client has no secret
+	wc, err := wechat.NewWeChatInMem("wxappid", "", "token",
+		wechat.WithAPIHost(s.APIHost()),
+		wechat.WithHTTPClient(s.Client()),
+		wechat.WithTokenServer(ts.URL+"/?account=wxappid", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := wc.GetUser("u1", ""); err != nil || u.Nickname != "Alice" {
+		t.Fatal(u, err)
+	}
+	if _, err := central.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Rejected token is refreshed by token server
+	s.ExpireToken()
+	if _, err := wc.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := central.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.TokenCount() != 2 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+
+	other, _ := wechat.NewWeChatInMem("wxappid", "", "token",
+		wechat.WithAPIHost(s.APIHost()),
+		wechat.WithHTTPClient(s.Client()),
+		wechat.WithTokenServer(ts.URL, "wrong"))
+	if _, err := other.GetUser("u1", ""); err == nil {
+		t.Error("token served with wrong key")
+	}
+}
+
+func TestMedia(t *testing.T) {
+	// Access token is never sent in cleartext
+	for _, u := range []string{wechat.WeChatMediaUpload, wechat.WeChatMediaGet} {
+		if !strings.HasPrefix(u, "https://") {
+			t.Error(u)
+		}
+	}
+	s, wc := newTestServer(t)
+	defer s.Close()
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 100)
+	id, created, err := wc.UploadMedia(wechat.MediaTypeImage, strings.NewReader(png), "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || time.Since(created) > time.Minute || string(s.Media(id)) != png {
+		t.Error(id, created)
+	}
+	body, contentType, err := wc.DownloadMedia(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(data) != png || contentType != "image/png" {
+		t.Error(contentType, len(data))
+	}
+	if _, _, err := wc.DownloadMedia("missing"); err == nil {
+		t.Error("missing media downloaded")
+	}
+	// Video is downloaded from video_url
+	video := "\x00\x00\x00\x18ftypmp42" + strings.Repeat("x", 100)
+	if id, _, err = wc.UploadMedia(wechat.MediaTypeVideo, strings.NewReader(video), "a.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if body, _, err = wc.DownloadMedia(id); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(body)
+	body.Close()
+	if string(data) != video {
+		t.Error(len(data))
+	}
+
+	// Error of server answering before reading the media is returned
+	s.Fail(503)
+	_, _, err = wc.UploadMedia(wechat.MediaTypeImage, strings.NewReader(strings.Repeat("x", 4<<20)), "a.png")
+	var status *wechat.ErrHTTPStatus
+	if !errors.As(err, &status) || status.StatusCode != 503 {
+		t.Error(err)
+	}
+
+	// Seekable media is uploaded again with refreshed access token
+	s.ExpireToken()
+	if id, _, err := wc.UploadMedia(wechat.MediaTypeImage, strings.NewReader(png), "a.png"); err != nil || string(s.Media(id)) != png {
+		t.Error(id, err)
+	}
+	s.ExpireToken()
+	if _, _, err := wc.UploadMedia(wechat.MediaTypeImage, struct{ io.Reader }{strings.NewReader(png)}, "a.png"); err == nil {
+		t.Error("stream uploaded with expired access token")
+	}
+
+	// Thumb is returned as thumb_media_id
+	if id, _, err := wc.UploadMedia(wechat.MediaTypeThumb, strings.NewReader("jpg"), "a.jpg"); err != nil || id == "" {
+		t.Error(id, err)
+	}
+	// Size is checked before upload, and while streaming if it is unknown
+	large := strings.Repeat("x", 65<<10)
+	if _, _, err := wc.UploadMedia(wechat.MediaTypeThumb, strings.NewReader(large), "a.jpg"); err != wechat.ErrMediaTooLarge {
+		t.Error(err)
+	}
+	if _, _, err := wc.UploadMedia(wechat.MediaTypeThumb, struct{ io.Reader }{strings.NewReader(large)}, "a.jpg"); err != wechat.ErrMediaTooLarge {
+		t.Error(err)
+	}
+	if _, _, err := wc.UploadMedia("file", strings.NewReader("x"), "a.txt"); err != wechat.ErrMediaType {
+		t.Error(err)
+	}
+}
+
+func TestMassSend(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	newsId, _, err := wc.UploadNews([]wechat.NewsArticle{{ThumbMediaId: "thumb", Title: "title", Content: "content"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &wechat.MassMessage{MsgType: wechat.MassMsgTypeNews, MediaId: newsId}
+	if err := wc.MassPreview("u1", msg); err != nil {
+		t.Fatal(err)
+	}
+	res, err := wc.MassSendTag(2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.MassSendOpenids([]string{"u1", "u2"}, &wechat.MassMessage{MsgType: wechat.MassMsgTypeText, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	mass := s.Mass()
+	if len(mass) != 3 ||
+		string(mass[0]) != `{"mpnews":{"media_id":"news_1"},"msgtype":"mpnews","send_ignore_reprint":0,"touser":"u1"}` ||
+		string(mass[1]) != `{"filter":{"is_to_all":false,"tag_id":2},"mpnews":{"media_id":"news_1"},"msgtype":"mpnews","send_ignore_reprint":0}` ||
+		string(mass[2]) != `{"msgtype":"text","text":{"content":"hi"},"touser":["u1","u2"]}` {
+		t.Errorf("%s", mass)
+	}
+	if status, err := wc.MassStatus(res.MsgId); err != nil || status != wechat.MassStatusSuccess {
+		t.Error(status, err)
+	}
+	if err := wc.MassDelete(res.MsgId); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := wc.MassStatus(res.MsgId); status != wechat.MassStatusDelete {
+		t.Error(status)
+	}
+
+	// Result is pushed by MASSSENDJOBFINISH event
+	done := make(chan *wechat.MassSendJobFinish)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		job, err := wc.WaitMassSend(ctx, res.MsgId)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- job
+	}()
+	c := wechattest.NewClient(wc, "token")
+	if _, err := c.Send(&wechat.Request{
+		MsgType:    "event",
+		Event:      wechat.EventMassSendJobFinish,
+		MsgID:      res.MsgId,
+		Status:     "send success",
+		TotalCount: 1,
+		SentCount:  1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if job := <-done; job == nil || job.MsgID != res.MsgId || job.SentCount != 1 {
+		t.Error(job)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	if err := wc.SetIndustry("1", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if primary, secondary, err := wc.GetIndustry(); err != nil || primary.SecondClass != "1" || secondary.SecondClass != "4" {
+		t.Error(primary, secondary, err)
+	}
+	id, err := wc.AddTemplate("TM00015")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, err := wc.GetTemplates(); err != nil || len(list) != 1 || list[0].TemplateId != id {
+		t.Error(list, err)
+	}
+	msg := &wechat.TemplateMessage{
+		ToUser:      "u1",
+		TemplateId:  id,
+		Url:         "http://example.com",
+		MiniProgram: &wechat.TemplateMiniProgram{AppId: "wxmini", PagePath: "index"},
+		Data: wechat.TemplateData{
+			"first":  {Value: "Hello", Color: "#173177"},
+			"remark": {Value: "Bye"},
+		},
+	}
+	msgId, err := wc.SendTemplate(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := s.TemplatesSent(); len(sent) != 1 || !reflect.DeepEqual(sent[0], msg) {
+		t.Error(sent)
+	}
+
+	// Result is pushed by TEMPLATESENDJOBFINISH event
+	jobs := make(chan *wechat.TemplateSendJobFinish, 1)
+	wc.OnTemplateSendJobFinish(func(j *wechat.TemplateSendJobFinish) {
+		jobs <- j
+	})
+	c := wechattest.NewClient(wc, "token")
+	if _, err := c.Send(&wechat.Request{
+		MsgType: "event",
+		Event:   wechat.EventTemplateSendJobFinish,
+		MsgID:   msgId,
+		Status:  "success",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case job := <-jobs:
+		if job.MsgID != msgId || job.Status != "success" {
+			t.Error(job)
+		}
+	case <-time.After(time.Second):
+		t.Error("template send callback not called")
+	}
+
+	if err := wc.DeleteTemplate(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.SendTemplate(msg); err == nil {
+		t.Error("deleted template sent")
+	}
+}
+
+func TestAsyncReply(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: wechattest.DefaultFromUserName})
+	wc, err := s.NewWeChat("token", wechat.WithAsync(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, done := make(chan struct{}), make(chan struct{})
+	wc.RegisterHandler(func(w wechat.RespondWriter, r *wechat.Request) error {
+		defer close(done)
+		<-release
+		w.ReplyText("he said \"hi\"\nbye")
+		return nil
+	}, wechat.MsgTypeText)
+	c := wechattest.NewClient(wc, "token")
+	if _, err := c.Text("slow"); err != nil {
+		t.Fatal(err)
+	}
+	// Late reply is sent through customer-service API
+	close(release)
+	<-done
+	sent := s.Sent()
+	if len(sent) != 1 {
+		t.Fatal(sent)
+	}
+	var msg struct {
+		ToUser string `json:"touser"`
+		Text   struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	}
+	if err := json.Unmarshal(sent[0], &msg); err != nil || msg.ToUser != wechattest.DefaultFromUserName || msg.Text.Content != "he said \"hi\"\nbye" {
+		t.Error(string(sent[0]), err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u2"})
+	s.AddUser(wechat.User{Openid: "u3"})
+	g, err := wc.CreateGroup(`"best" friends`)
+	if err != nil || g.Name != `"best" friends` {
+		t.Fatal(g, err)
+	}
+	if err := wc.UpdateGroup(g.Id, "family"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.MoveUserToGroup("u1", g.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.MoveUsersToGroup([]string{"u2", "u3"}, g.Id); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := wc.GetGroups()
+	if err != nil || len(groups) != 1 || groups[0] != (wechat.Group{Id: g.Id, Name: "family", Count: 3}) {
+		t.Error(groups, err)
+	}
+	if id, err := wc.GetUserGroup("u2"); err != nil || id != g.Id {
+		t.Error(id, err)
+	}
+	if err := wc.DeleteGroup(g.Id); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := wc.GetUserGroup("u2"); err != nil || id != 0 {
+		t.Error(id, err)
+	}
+	if err := wc.MoveUserToGroup("u1", g.Id); err == nil {
+		t.Error("moved to deleted group")
+	}
+}
+
+func TestTag(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u2", Remark: "Bob", Unionid: "union2", SubscribeScene: "ADD_SCENE_QR_CODE", QrScene: 98765})
+	tag, err := wc.CreateTag("vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.UpdateTag(tag.Id, "svip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.TagUsers(tag.Id, []string{"u1", "u2"}); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := wc.GetTags()
+	if err != nil || len(tags) != 1 || tags[0] != (wechat.Tag{Id: tag.Id, Name: "svip", Count: 2}) {
+		t.Error(tags, err)
+	}
+	if ids, err := wc.GetUserTags("u2"); err != nil || !reflect.DeepEqual(ids, []int{tag.Id}) {
+		t.Error(ids, err)
+	}
+	u, err := wc.GetUser("u2", "")
+	if err != nil || !reflect.DeepEqual(u.TagidList, []int{tag.Id}) || u.Remark != "Bob" || u.Unionid != "union2" ||
+		u.SubscribeScene != "ADD_SCENE_QR_CODE" || u.QrScene != 98765 {
+		t.Error(u, err)
+	}
+	if err := wc.UntagUsers(tag.Id, []string{"u1"}); err != nil {
+		t.Fatal(err)
+	}
+	ids, next, err := wc.GetTagUsers(tag.Id, "")
+	if err != nil || !reflect.DeepEqual(ids, []string{"u2"}) {
+		t.Error(ids, err)
+	}
+	if ids, next, err = wc.GetTagUsers(tag.Id, next); err != nil || len(ids) != 0 || next != "" {
+		t.Error(ids, next, err)
+	}
+	if err := wc.DeleteTag(tag.Id); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := wc.GetUserTags("u2"); len(ids) != 0 {
+		t.Error(ids)
+	}
+}
+
+func TestStreamUsers(t *testing.T) {
+	s, wc := newTestServer(t)
+	defer s.Close()
+	s.UserPageSize = 100
+	for i := 2; i <= 250; i++ {
+		s.AddUser(wechat.User{Openid: fmt.Sprintf("u%d", i), Nickname: fmt.Sprintf("user%d", i)})
+	}
+	pages, count := 0, 0
+	it := wc.Followers(context.Background())
+	for it.Next() {
+		pages++
+		count += len(it.Page())
+	}
+	if it.Err() != nil || pages != 3 || count != 250 || it.Total() != 250 {
+		t.Error(pages, count, it.Total(), it.Err())
+	}
+
+	if _, err := wc.BatchGetUsers(make([]string, 101), ""); err != wechat.ErrTooManyUsers {
+		t.Error(err)
+	}
+	users, errs := wc.StreamUsers(context.Background(), "", 3)
+	seen := map[string]bool{}
+	for u := range users {
+		if u.Nickname == "" || seen[u.Openid] {
+			t.Error(u)
+		}
+		seen[u.Openid] = true
+	}
+	if err := <-errs; err != nil || len(seen) != 250 {
+		t.Error(len(seen), err)
+	}
+
+	// Error stops the stream
+	s.Fail(wechattest.ErrCodeInvalidAppid)
+	users, errs = wc.StreamUsers(context.Background(), "", 3)
+	for range users {
+	}
+	if err := <-errs; err == nil {
+		t.Error("error not returned")
+	}
+
+	// Workers stop when the consumer cancels without draining users
+	ctx, cancel := context.WithCancel(context.Background())
+	users, errs = wc.StreamUsers(ctx, "", 3)
+	<-users
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stream not stopped by cancel")
+	}
+	for range users {
+	}
+}
+
+func TestSyncUsers(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	for i := 1; i <= 3; i++ {
+		s.AddUser(wechat.User{Openid: fmt.Sprintf("u%d", i), Nickname: fmt.Sprintf("user%d", i)})
+	}
+	wc, err := s.NewWeChat("token", wechat.WithUserSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.StartUserSync(context.Background(), 0); err != wechat.ErrSyncInterval {
+		t.Error(err)
+	}
+	if err := wc.SyncUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := wc.ReadUser("u2"); err != nil || u.Nickname != "user2" {
+		t.Error(u, err)
+	}
+	s.RemoveUser("u3")
+	if err := wc.SyncUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.ReadUser("u3"); err != wechat.ErrUserNotFound {
+		t.Error(err)
+	}
+
+	// Followers are updated by events
+	s.AddUser(wechat.User{Openid: "u4", Nickname: "user4"})
+	c := wechattest.NewClient(wc, "token")
+	c.FromUserName = "u4"
+	if _, err := c.Event(wechat.EventSubscribe, ""); err != nil {
+		t.Fatal(err)
+	}
+	c.FromUserName = "u2"
+	if _, err := c.Event(wechat.EventUnsubscribe, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err4 := wc.ReadUser("u4")
+		_, err2 := wc.ReadUser("u2")
+		if err4 == nil && err2 == wechat.ErrUserNotFound {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("followers are not updated by events")
+}
+
+//Storage failing to save users
+type failUserStorage struct {
+	*wechat.MemStorage
+	saved int
+}
+
+func (s *failUserStorage) SaveUser(u *wechat.User, synced time.Time) error {
+	s.saved++
+	return errors.New("save failed")
+}
+
+func TestSyncUsersError(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	s.UserPageSize = 100
+	for i := 1; i <= 1000; i++ {
+		s.AddUser(wechat.User{Openid: fmt.Sprintf("u%d", i)})
+	}
+	storage := &failUserStorage{MemStorage: wechat.NewMemStorage("wxappid", "secret", "token")}
+	wc, err := wechat.New(storage, wechat.WithAPIHost(s.APIHost()), wechat.WithHTTPClient(s.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.SyncUsers(context.Background()); err == nil || err.Error() != "save failed" || storage.saved != 1 {
+		t.Error(err, storage.saved)
+	}
+}
+
+//Storage whose first read returns the token read before another refresh
+//finished. It does not implement LockStorage.
+type staleTokenStorage struct {
+	wechat.Storage
+	mu    sync.Mutex
+	stale bool
+}
+
+func (s *staleTokenStorage) ReadAccessToken() (wechat.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stale {
+		s.stale = true
+		return wechat.AccessToken{Token: "stale"}, nil
+	}
+	return s.Storage.ReadAccessToken()
+}
+
+func TestTokenRefreshedBeforeFetch(t *testing.T) {
+	s := wechattest.NewServer("wxappid", "secret")
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u1"})
+	storage := wechat.NewMemStorage("wxappid", "secret", "token")
+	options := []wechat.Option{wechat.WithAPIHost(s.APIHost()), wechat.WithHTTPClient(s.Client())}
+	first, _ := wechat.New(storage, options...)
+	if _, err := first.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := wechat.New(&staleTokenStorage{Storage: storage}, options...)
+	if _, err := second.GetUser("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.TokenCount() != 1 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
@@ -0,0 +1,57 @@
+package wechat
+
+import (
+	"errors"
+	"time"
+)
+
+//Reply to WeChat server when the passive reply timed out,
+//WeChat will not retry the message and shows nothing to user.
+const replySuccess = "success"
+
+//Conversation can only be transferred to customer service by passive reply.
+var ErrTransferTimeout = errors.New("wechat: can not transfer to customer service after reply timeout")
+
+//Default deadline of passive reply, WeChat waits 5 seconds at most.
+const DefaultAsyncDeadline = 4500 * time.Millisecond
+
+//Run handlers in async mode. If a handler runs past the deadline,
+//ServeHTTP answers "success" immediately, and any later reply of the
+//handler is sent through the customer-service API (PostText, PostNews...).
+//Customer-service messages only work for Service Account.
+func WithAsync(deadline time.Duration) Option {
+	return func(w *WeChat) error {
+		if deadline <= 0 {
+			deadline = DefaultAsyncDeadline
+		}
+		w.asyncDeadline = deadline
+		return nil
+	}
+}
+
+//Call handler in goroutine, detach the respond if it does not finish in time.
+func (wc *WeChat) handleAsync(resp *Respond, msg *Request) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wc.handle(resp, msg)
+	}()
+	timer := time.NewTimer(wc.asyncDeadline)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		resp.detach()
+	}
+}
+
+//Stop passive reply, later replies will be posted through customer-service API.
+//If the handler has already replied, the reply is still sent passively.
+func (r *Respond) detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.detached = true
+	if r.out == "" {
+		r.out = replySuccess
+	}
+}
@@ -0,0 +1,32 @@
+package wechat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsync(t *testing.T) {
+	wc, err := New(&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}},
+		WithAsync(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		if r.Content == "slow" {
+			<-release
+			return nil
+		}
+		w.ReplyText("fast")
+		return nil
+	}, MsgTypeText)
+
+	if reply := testServe(wc, testText("fast", "1")).Body.String(); !strings.Contains(reply, "<Content><![CDATA[fast]]></Content>") {
+		t.Error(reply)
+	}
+	if reply := testServe(wc, testText("slow", "2")).Body.String(); reply != replySuccess {
+		t.Error(reply)
+	}
+	close(release)
+}
@@ -0,0 +1,165 @@
+package wechat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Message encoding mode of WeChat callbacks
+type EncodingMode int
+
+const (
+	EncodingPlain      EncodingMode = iota // Plaintext messages only
+	EncodingCompatible                     // Accept plaintext and encrypted messages
+	EncodingSafe                           // Accept encrypted messages only
+)
+
+var (
+	ErrInvalidAESKey    = errors.New("wechat: invalid EncodingAESKey")
+	ErrInvalidEncrypted = errors.New("wechat: invalid encrypted message")
+	ErrAppidMismatch    = errors.New("wechat: appid of encrypted message mismatch")
+)
+
+//Option of WeChat, passed to New
+type Option func(*WeChat) error
+
+//Use EncodingAESKey to decrypt requests and encrypt replies.
+func WithEncodingAESKey(key string, mode EncodingMode) Option {
+	return func(w *WeChat) error {
+		if mode == EncodingPlain {
+			w.mode = mode
+			return nil
+		}
+		c, err := NewMsgCrypt(key, w.appid)
+		if err != nil {
+			return err
+		}
+		w.crypt = c
+		w.mode = mode
+		return nil
+	}
+}
+
+//AES-CBC message crypt of WeChat, used by safe and compatible mode.
+type MsgCrypt struct {
+	appid string
+	key   []byte
+}
+
+//Create message crypt with EncodingAESKey and appid of account.
+func NewMsgCrypt(encodingAESKey, appid string) (*MsgCrypt, error) {
+	if len(encodingAESKey) != 43 {
+		return nil, ErrInvalidAESKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidAESKey
+	}
+	return &MsgCrypt{appid: appid, key: key}, nil
+}
+
+//Decrypt the content of <Encrypt>, and check the appid suffix.
+func (c *MsgCrypt) Decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, ErrInvalidEncrypted
+	}
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, c.key[:aes.BlockSize]).CryptBlocks(plain, data)
+	// WeChat pads to 32 bytes with PKCS#7
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > 32 || pad > len(plain) {
+		return nil, ErrInvalidEncrypted
+	}
+	plain = plain[:len(plain)-pad]
+	// random(16) + length(4) + message + appid
+	if len(plain) < 20 {
+		return nil, ErrInvalidEncrypted
+	}
+	size := int(binary.BigEndian.Uint32(plain[16:20]))
+	if size < 0 || 20+size > len(plain) {
+		return nil, ErrInvalidEncrypted
+	}
+	if string(plain[20+size:]) != c.appid {
+		return nil, ErrAppidMismatch
+	}
+	return plain[20 : 20+size], nil
+}
+
+//Encrypt message, the result is base64 encoded.
+func (c *MsgCrypt) Encrypt(msg []byte) (string, error) {
+	buf := make([]byte, 20, 20+len(msg)+len(c.appid)+32)
+	if _, err := rand.Read(buf[:16]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint32(buf[16:20], uint32(len(msg)))
+	buf = append(buf, msg...)
+	buf = append(buf, c.appid...)
+	pad := 32 - len(buf)%32
+	buf = append(buf, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return "", err
+	}
+	cipher.NewCBCEncrypter(block, c.key[:aes.BlockSize]).CryptBlocks(buf, buf)
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+//Envelope of encrypted request
+type encryptedRequest struct {
+	XMLName    xml.Name `xml:"xml"`
+	ToUserName string
+	Encrypt    string
+}
+
+//Envelope of encrypted reply
+type encryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      cdata
+	MsgSignature cdata
+	TimeStamp    string
+	Nonce        cdata
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+//Encrypt reply and wrap it into <Encrypt> envelope.
+func (c *MsgCrypt) EncryptReply(token, timestamp, nonce string, reply []byte) ([]byte, error) {
+	encrypted, err := c.Encrypt(reply)
+	if err != nil {
+		return nil, err
+	}
+	return xml.Marshal(&encryptedReply{
+		Encrypt:      cdata{encrypted},
+		MsgSignature: cdata{Signature(token, timestamp, nonce, encrypted)},
+		TimeStamp:    timestamp,
+		Nonce:        cdata{nonce},
+	})
+}
+
+//Signature of WeChat, SHA1 of sorted and concatenated strings.
+func Signature(strs ...string) string {
+	sort.Strings(strs)
+	h := sha1.New()
+	h.Write([]byte(strings.Join(strs, "")))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
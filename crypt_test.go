@@ -0,0 +1,86 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+
+func TestMsgCrypt(t *testing.T) {
+	c, err := NewMsgCrypt(testAESKey, "wxappid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := c.Encrypt([]byte("<xml>你好</xml>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := c.Decrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dec) != "<xml>你好</xml>" {
+		t.Error(string(dec))
+	}
+	other, _ := NewMsgCrypt(testAESKey, "wxother")
+	if _, err := other.Decrypt(enc); err != ErrAppidMismatch {
+		t.Error(err)
+	}
+	if _, err := NewMsgCrypt("short", "wxappid"); err != ErrInvalidAESKey {
+		t.Error(err)
+	}
+}
+
+func TestServeEncrypted(t *testing.T) {
+	wc, err := New(&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}},
+		WithEncodingAESKey(testAESKey, EncodingSafe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		w.ReplyText("echo:" + r.Content)
+		return nil
+	}, MsgTypeText)
+
+	enc, _ := wc.crypt.Encrypt([]byte(`<xml><ToUserName><![CDATA[gh_1]]></ToUserName><FromUserName><![CDATA[user]]></FromUserName><CreateTime>1</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hi]]></Content><MsgId>1</MsgId></xml>`))
+	body := `<xml><ToUserName><![CDATA[gh_1]]></ToUserName><Encrypt><![CDATA[` + enc + `]]></Encrypt></xml>`
+	q := url.Values{
+		"timestamp":     {"1400000000"},
+		"nonce":         {"abc"},
+		"signature":     {Signature("token", "1400000000", "abc")},
+		"encrypt_type":  {"aes"},
+		"msg_signature": {Signature("token", "1400000000", "abc", enc)},
+	}
+	rec := httptest.NewRecorder()
+	wc.ServeHTTP(rec, httptest.NewRequest("POST", "/?"+q.Encode(), strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatal(rec.Code)
+	}
+	reply := &encryptedReply{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), reply); err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+	if Signature("token", reply.TimeStamp, reply.Nonce.Value, reply.Encrypt.Value) != reply.MsgSignature.Value {
+		t.Error("bad reply signature")
+	}
+	dec, err := wc.crypt.Decrypt(reply.Encrypt.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dec), "<Content><![CDATA[echo:hi]]></Content>") {
+		t.Error(string(dec))
+	}
+
+	// Plaintext request is rejected in safe mode
+	q.Del("encrypt_type")
+	rec = httptest.NewRecorder()
+	wc.ServeHTTP(rec, httptest.NewRequest("POST", "/?"+q.Encode(), strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Error(rec.Code)
+	}
+}
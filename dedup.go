@@ -0,0 +1,53 @@
+package wechat
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// How long a received message is remembered, WeChat retries within 15 seconds.
+	DedupExpire = 60 * time.Second
+	// How long a retry waits for the reply of the first delivery.
+	dedupWait = 4 * time.Second
+	// Interval to poll the reply of the first delivery.
+	dedupPoll = 100 * time.Millisecond
+)
+
+//Storage of received messages, used to deduplicate retried callbacks.
+//If the Storage passed to New implements this interface, ServeHTTP
+//handles every message only once and replays the first reply to retries.
+type DedupStorage interface {
+	MarkMessage(key string) (first bool, err error)                   // Mark message as received, first is false if it was received before
+	SaveMessageReply(key, reply string) error                         // Save the reply of message, empty reply means no reply
+	ReadMessageReply(key string) (reply string, done bool, err error) // Read the reply of message, done is false if it is still being handled
+}
+
+//Key of message used to deduplicate retries.
+//Messages are keyed by MsgId, events by FromUserName and CreateTime,
+//and job finish events also by MsgID of the job.
+func (r *Request) dedupKey() string {
+	if r.MsgId != 0 {
+		return strconv.FormatInt(r.MsgId, 10)
+	}
+	key := r.FromUserName + "#" + strconv.Itoa(r.CreateTime)
+	if r.isEvent(EventMassSendJobFinish, EventTemplateSendJobFinish) {
+		key += "#" + strconv.FormatInt(r.MsgID, 10)
+	}
+	return key
+}
+
+//Wait the reply of the first delivery.
+func waitReply(ds DedupStorage, key string) (string, bool) {
+	deadline := time.Now().Add(dedupWait)
+	for {
+		reply, done, err := ds.ReadMessageReply(key)
+		if err != nil || done {
+			return reply, done
+		}
+		if time.Now().After(deadline) {
+			return "", false
+		}
+		time.Sleep(dedupPoll)
+	}
+}
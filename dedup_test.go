@@ -0,0 +1,88 @@
+package wechat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		count++
+		w.ReplyText("order accepted")
+		return nil
+	}, MsgTypeText)
+
+	var replies []string
+	for i := 0; i < 3; i++ {
+		replies = append(replies, testServe(wc, testText("buy", "42")).Body.String())
+	}
+	if count != 1 {
+		t.Error("handler called", count, "times")
+	}
+	for _, reply := range replies {
+		if reply != replies[0] || !strings.Contains(reply, "order accepted") {
+			t.Error(reply)
+		}
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	if k := (&Request{MsgId: 42}).dedupKey(); k != "42" {
+		t.Error(k)
+	}
+	if k := (&Request{FromUserName: "user", CreateTime: 1}).dedupKey(); k != "user#1" {
+		t.Error(k)
+	}
+	// Jobs finished in the same second are sent by the same user
+	a := &Request{FromUserName: "mphelper", CreateTime: 1, MsgType: msgEvent, Event: EventMassSendJobFinish, MsgID: 1001}
+	b := &Request{FromUserName: "mphelper", CreateTime: 1, MsgType: msgEvent, Event: EventMassSendJobFinish, MsgID: 1002}
+	if a.dedupKey() == b.dedupKey() {
+		t.Error(a.dedupKey())
+	}
+}
+
+func TestDedupJobFinish(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		count++
+		return nil
+	}, MsgTypeEventMassSendJobFinish)
+	for _, id := range []string{"1001", "1002", "1002"} {
+		testServe(wc, `<xml><FromUserName>mphelper</FromUserName><CreateTime>1</CreateTime><MsgType>event</MsgType><Event>MASSSENDJOBFINISH</Event><MsgID>`+id+`</MsgID></xml>`)
+	}
+	if count != 2 {
+		t.Error("handler called", count, "times")
+	}
+}
+
+func TestMarkMessageExpiry(t *testing.T) {
+	s := &MemStorage{}
+	for _, key := range []string{"1", "2"} {
+		if first, err := s.MarkMessage(key); !first || err != nil {
+			t.Fatal(key, first, err)
+		}
+	}
+	// Expire message 1
+	old := time.Now().Add(-DedupExpire - time.Second)
+	s.messages["1"].Time = old
+	s.expiry[0].expire = old.Add(DedupExpire)
+	if first, _ := s.MarkMessage("3"); !first {
+		t.Error("3 marked before")
+	}
+	if _, ok := s.messages["1"]; ok || len(s.messages) != 2 || len(s.expiry) != 2 {
+		t.Error(len(s.messages), len(s.expiry))
+	}
+	if first, _ := s.MarkMessage("2"); first {
+		t.Error("2 expired")
+	}
+}
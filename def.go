@@ -5,12 +5,18 @@ package wechat
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,6 +27,12 @@ const (
 	LANG_EN = `en`    // English
 )
 
+//Timeouts of WeChat
+const (
+	ReplyTimeout      = 5 * time.Second  // WeChat waits 5 seconds for passive reply
+	DefaultAPITimeout = 30 * time.Second // Timeout of API call, if context has no deadline
+)
+
 //WeChat URL info
 const (
 	// WeChat host URL
@@ -28,17 +40,45 @@ const (
 	//WeChat Reply
 	WeChatPost   = WeChatHost + `message/custom/send?access_token=%v`
 	WeChatUpload = WeChatHost + `media/uploadnews?access_token=%v`
+	//WeChat Mass
+	WeChatMass        = WeChatHost + `message/mass`
+	WeChatMassSendAll = WeChatMass + `/sendall?access_token=%v`
+	WeChatMassSend    = WeChatMass + `/send?access_token=%v`
+	WeChatMassPreview = WeChatMass + `/preview?access_token=%v`
+	WeChatMassDelete  = WeChatMass + `/delete?access_token=%v`
+	WeChatMassGet     = WeChatMass + `/get?access_token=%v`
+	//WeChat Template
+	WeChatTemplate            = WeChatHost + `template`
+	WeChatTemplateSetIndustry = WeChatTemplate + `/api_set_industry?access_token=%v`
+	WeChatTemplateGetIndustry = WeChatTemplate + `/get_industry?access_token=%v`
+	WeChatTemplateAdd         = WeChatTemplate + `/api_add_template?access_token=%v`
+	WeChatTemplateList        = WeChatTemplate + `/get_all_private_template?access_token=%v`
+	WeChatTemplateDelete      = WeChatTemplate + `/del_private_template?access_token=%v`
+	WeChatTemplateSend        = WeChatHost + `message/template/send?access_token=%v`
 	//WeChat User
-	WeChatUser       = WeChatHost + `user`
-	WeChatUserGet    = WeChatUser + `/info?openid=%v&lang=%v&access_token=`
-	WeChatUserGetAll = WeChatUser + `/get?next_openid=%v&access_token=`
+	WeChatUser         = WeChatHost + `user`
+	WeChatUserGet      = WeChatUser + `/info?openid=%v&lang=%v&access_token=`
+	WeChatUserGetAll   = WeChatUser + `/get?next_openid=%v&access_token=`
+	WeChatTagUsers     = WeChatUser + `/tag/get?access_token=%v`
+	WeChatUserBatchGet = WeChatUser + `/info/batchget?access_token=%v`
+	//WeChat Tag
+	WeChatTag               = WeChatHost + `tags`
+	WeChatTagCreate         = WeChatTag + `/create?access_token=%v`
+	WeChatTagGet            = WeChatTag + `/get?access_token=%v`
+	WeChatTagUpdate         = WeChatTag + `/update?access_token=%v`
+	WeChatTagDelete         = WeChatTag + `/delete?access_token=%v`
+	WeChatTagBatchTagging   = WeChatTag + `/members/batchtagging?access_token=%v`
+	WeChatTagBatchUntagging = WeChatTag + `/members/batchuntagging?access_token=%v`
+	WeChatTagGetIdList      = WeChatTag + `/getidlist?access_token=%v`
 	//WeChat Group
-	WeChatGroup             = WeChatHost + `groups`
-	WeChatGroupCreate       = WeChatGroup + `/create?access_token=%v`
-	WeChatGroupGet          = WeChatGroup + `/get?access_token=%v`
-	WeChatGroupUpdate       = WeChatGroup + `/update?access_token=%v`
-	WeChatGroupMemberUpdate = WeChatGroup + `/members/update?access_token=%v`
-	WeChatGroupGetIdByUser  = WeChatGroup + `getid?access_token=%v`
+	WeChatGroup                  = WeChatHost + `groups`
+	WeChatGroupCreate            = WeChatGroup + `/create?access_token=%v`
+	WeChatGroupGet               = WeChatGroup + `/get?access_token=%v`
+	WeChatGroupUpdate            = WeChatGroup + `/update?access_token=%v`
+	WeChatGroupDelete            = WeChatGroup + `/delete?access_token=%v`
+	WeChatGroupMemberUpdate      = WeChatGroup + `/members/update?access_token=%v`
+	WeChatGroupMemberBatchUpdate = WeChatGroup + `/members/batchupdate?access_token=%v`
+	WeChatGroupGetIdByUser       = WeChatGroup + `/getid?access_token=%v`
 	//WeChat Menu
 	WeChatMenu       = WeChatHost + `menu`
 	WeChatMenuCreate = WeChatMenu + `/create?access_token=%v`
@@ -51,44 +91,105 @@ const (
 	WeChatQRSceneCreate = WeChatQRScene + `/create?access_token=%v`
 	WeChatShowQRScene   = "https://mp.weixin.qq.com/cgi-bin/showqrcode"
 	//File
-	WeChatFileURL = "http://file.api.weixin.qq.com/cgi-bin/media"
+	WeChatFileURL     = "http://file.api.weixin.qq.com/cgi-bin/media" // Plain http, media API uses WeChatHost
+	WeChatMediaUpload = WeChatHost + `media/upload?access_token=%v&type=%v`
+	WeChatMediaGet    = WeChatHost + `media/get?access_token=%v&media_id=%v`
 )
 
 // Basic struct of wechat.
 type WeChat struct {
-	appid  string   // Appid of wechat
-	secret string   // App secret of wechat
-	token  string   // App token of wechat, this is defined by user.
-	atrw   Storage  // Storage interface, this interface used to store the limit resource.
-	routes []*Route // Route of request handler
+	appid  string       // Appid of wechat
+	secret string       // App secret of wechat
+	token  string       // App token of wechat, this is defined by user.
+	atrw   Storage      // Storage interface, this interface used to store the limit resource.
+	routes []*Route     // Route of request handler
+	mode   EncodingMode // Message encoding mode
+	crypt  *MsgCrypt    // Message crypt, used when mode is not plain
+
+	asyncDeadline time.Duration // Deadline of handler in async mode, 0 means sync mode
+	middlewares   []Middleware  // Middlewares wrap every route handler
+	fallback      *Route        // Route used when no route matches
+	logger        Logger        // Logger of errors
+	onError       ErrorHandler  // Handler of errors returned by handlers
+	replayWindow  time.Duration // Window of callback timestamp, 0 means no replay check
+	nonces        *nonceCache   // Used nonces, if storage is not NonceStorage
+	host          string        // Base URL of API, replaces WeChatHost
+	client        *http.Client  // HTTP client of API
+	retry         RetryPolicy   // Retry policy of API
+	tokenRenewal  time.Duration // Renew access token in background this long before it expires
+	tokenMu       sync.Mutex    // Guard tokenCall
+	tokenCall     *tokenCall    // Access token refresh in flight
+	source        TokenSource   // Source of access token, nil means WeChat server
+	jobs          jobTracker    // Jobs of mass messages
+	userSync      bool          // Update followers in UserStorage by events
+	routeMu       sync.RWMutex  // Guard routes, middlewares, fallback and onTemplateSend, which may be set while serving
+
+	onTemplateSend func(job *TemplateSendJobFinish) // Callback of template message results
 }
 
 //Register Route
 func (w *WeChat) RegisterHandler(handler HandleFunc, patterns ...string) {
 	for _, pattern := range patterns {
-		reg, err := regexp.Compile(pattern)
-		if err != nil {
-			panic(err)
-		}
-		w.routes = append(w.routes, &Route{
-			Regex:  reg,
-			Handle: handler,
-		})
+		w.RegisterRoute(pattern, handler)
+	}
+}
+
+//Register Route with middlewares, they run after the middlewares added by Use.
+func (w *WeChat) RegisterRoute(pattern string, handler HandleFunc, middlewares ...Middleware) {
+	w.AddRoute(&Route{
+		Regex:       regexp.MustCompile(pattern),
+		Handle:      handler,
+		Middlewares: middlewares,
+	})
+}
+
+//Add route. Routes are matched by priority from high to low,
+//routes with the same priority are matched in the order they are added.
+func (w *WeChat) AddRoute(route *Route) {
+	w.routeMu.Lock()
+	defer w.routeMu.Unlock()
+	i := sort.Search(len(w.routes), func(i int) bool {
+		return w.routes[i].Priority < route.Priority
+	})
+	w.routes = append(w.routes, nil)
+	copy(w.routes[i+1:], w.routes[i:])
+	w.routes[i] = route
+}
+
+//Set the handler used when no route matches the request.
+func (w *WeChat) SetDefaultHandler(handler HandleFunc, middlewares ...Middleware) {
+	w.routeMu.Lock()
+	defer w.routeMu.Unlock()
+	w.fallback = &Route{
+		Handle:      handler,
+		Middlewares: middlewares,
 	}
 }
 
 //Create wechat struct.
-func New(storage Storage) (*WeChat, error) {
+func New(storage Storage, options ...Option) (*WeChat, error) {
 	appid, secret, token, err := storage.WeChatInfo()
 	if err != nil {
 		return nil, err
 	}
-	return &WeChat{
+	w := &WeChat{
 		appid:  appid,
 		secret: secret,
 		token:  token,
 		atrw:   storage,
-	}, nil
+		logger: stdLogger{},
+		host:   WeChatHost,
+		client: http.DefaultClient,
+		retry:  DefaultRetryPolicy,
+
+		tokenRenewal: DefaultTokenRenewal,
+	}
+	for _, option := range options {
+		if err := option(w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
 }
 
 //Handle Func
@@ -96,8 +197,19 @@ type HandleFunc func(RespondWriter, *Request) error
 
 //Route of request handler
 type Route struct {
-	Regex  *regexp.Regexp //Regexp of words that use this Handle
-	Handle HandleFunc     // Handle function
+	Regex       *regexp.Regexp //Regexp of words that use this Handle
+	Match       MatchFunc      // Predicate on request, nil matches every request
+	Priority    int            // Priority of route, higher is matched first
+	Handle      HandleFunc     // Handle function
+	Middlewares []Middleware   // Middlewares of this route
+}
+
+//Match request
+func (route *Route) match(requestPath string, r *Request) bool {
+	if route.Regex != nil && !route.Regex.MatchString(requestPath) {
+		return false
+	}
+	return route.Match == nil || route.Match(r)
 }
 
 // Access Token, we need this to verify the identity with WeChat server.
@@ -107,27 +219,6 @@ type AccessToken struct {
 	ExpireTime time.Time `json:"expires_in"`   // ExpireTime of Access Token
 }
 
-//Get Access Token
-func (w *WeChat) getAccessToken() (AccessToken, error) {
-	at, err := w.atrw.ReadAccessToken()
-	if err == nil && time.Since(at.ExpireTime).Seconds() < 0 && at.Token != "" {
-		return at, nil
-	}
-	res := AccessToken{}
-	var xxx struct {
-		Token  string `json:"access_token"` // Access Token
-		Expire int64  `json:"expires_in"`   // ExpireTime of Access Token
-
-	}
-	err = w.get(fmt.Sprintf(WeChatToken, w.appid, w.secret), &xxx, false)
-	if err == nil {
-		res.Token = xxx.Token
-		res.ExpireTime = time.Now().Add(time.Duration(xxx.Expire) * time.Second)
-		go w.atrw.WriteAccessToken(res)
-	}
-	return res, err
-}
-
 //WeChat server respond code
 type ErrWeChat struct {
 	ErrCode int    `json:"errcode"`
@@ -138,80 +229,156 @@ func (e *ErrWeChat) Error() string {
 	return strconv.Itoa(e.ErrCode) + ":" + e.ErrMsg
 }
 
+//Use host as base URL of API instead of WeChatHost, such as a fake server in tests.
+//The host must end with "/".
+func WithAPIHost(host string) Option {
+	return func(w *WeChat) error {
+		if !strings.HasSuffix(host, "/") {
+			return errors.New("wechat: API host must end with /")
+		}
+		w.host = host
+		return nil
+	}
+}
+
+//Use client to call API, the default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(w *WeChat) error {
+		w.client = client
+		return nil
+	}
+}
+
+//Replace the host of API url.
+func (w *WeChat) apiURL(url string) string {
+	if w.host == WeChatHost {
+		return url
+	}
+	if strings.HasPrefix(url, WeChatHost) {
+		return w.host + strings.TrimPrefix(url, WeChatHost)
+	}
+	return url
+}
+
+//Send request to WeChat server, and read the whole body.
+//If ctx has no deadline, DefaultAPITimeout is used.
+func (w *WeChat) do(ctx context.Context, method, url string, data []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultAPITimeout)
+		defer cancel()
+	}
+	var body io.Reader
+	var contentType string
+	if data != nil {
+		body = bytes.NewReader(data)
+		contentType = "application/json; charset=utf-8"
+	}
+	resp, err := w.open(ctx, method, url, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+//Send request to WeChat server, the body of response must be closed.
+func (w *WeChat) open(ctx context.Context, method, url string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.apiURL(url), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &ErrHTTPStatus{StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
 //Get information from WeChat server.
-func (w *WeChat) get(url string, out interface{}, needAccessToken bool) error {
-	ewc := &ErrWeChat{}
-	for i := 1; i <= 3; i++ {
-		ewc.ErrCode = -9999
+func (w *WeChat) get(ctx context.Context, url string, out interface{}, needAccessToken bool) error {
+	return w.call(ctx, "GET", url, nil, out, needAccessToken, true)
+}
+
+//Post json to WeChat server.
+//It is not retried if WeChat server may have handled it, such as sending messages.
+func (w *WeChat) post(ctx context.Context, url string, data []byte, out interface{}) error {
+	return w.call(ctx, "POST", url, data, out, true, false)
+}
+
+//Post json to WeChat server, the request is safe to retry.
+func (w *WeChat) postIdempotent(ctx context.Context, url string, data []byte, out interface{}) error {
+	return w.call(ctx, "POST", url, data, out, true, true)
+}
+
+//Post data as json, it is not retried like post.
+func (w *WeChat) postJSON(ctx context.Context, url string, data interface{}, out interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, url, body, out)
+}
+
+//Post data as json, the request is safe to retry.
+func (w *WeChat) postJSONIdempotent(ctx context.Context, url string, data interface{}, out interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return w.postIdempotent(ctx, url, body, out)
+}
+
+//Call API of WeChat server, retry by the retry policy of WeChat.
+func (w *WeChat) call(ctx context.Context, method, url string, data []byte, out interface{}, needAccessToken, idempotent bool) error {
+	return w.retryCall(ctx, url, needAccessToken, idempotent, func(url string) error {
+		return w.callOnce(ctx, method, url, data, out)
+	})
+}
+
+//Call once with url, in which access token is filled, retry it by policy.
+func (w *WeChat) retryCall(ctx context.Context, url string, needAccessToken, idempotent bool, once func(url string) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
 		urlx := url
+		var token string
 		if needAccessToken {
-			at, err := w.getAccessToken()
+			at, err := w.getAccessToken(ctx)
 			if err != nil {
 				return err
 			}
-			urlx = fmt.Sprintf(url, at.Token)
+			token = at.Token
+			urlx = fmt.Sprintf(url, token)
 		}
-		resp, err := http.Get(fmt.Sprintf(urlx))
-		if err != nil {
+		err = once(urlx)
+		retry, refresh := w.retry.classify(err, needAccessToken, idempotent)
+		if !retry || attempt >= w.retry.MaxAttempts {
 			return err
 		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
+		if refresh {
+			// Token is invalid, WeChat server rejected the request
+			w.invalidateAccessToken(token)
+			continue
 		}
-		//fmt.Println(url, string(body))
-		if er := json.Unmarshal(body, ewc); er != nil {
-			return er
-		} else {
-			switch ewc.ErrCode {
-			case -9999:
-				return json.Unmarshal(body, out)
-			case 0:
-				return nil
-			case 42001:
-				continue
-			default:
-				return ewc
-			}
+		if err := w.retry.wait(ctx, attempt); err != nil {
+			return err
 		}
 	}
-	return ewc
 }
 
-//Post json to WeChat server.
-func (w *WeChat) post(url string, data []byte, out interface{}) error {
-	ewc := &ErrWeChat{}
-	for i := 1; i <= 3; i++ {
-		ewc.ErrCode = -9999
-		at, err := w.getAccessToken()
-		if err != nil {
-			return err
-		}
-		resp, err := http.Post(fmt.Sprintf(url, at.Token), "application/json; charset=utf-8", bytes.NewReader(data))
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		if er := json.Unmarshal(body, ewc); er != nil {
-			return err
-		} else {
-			switch ewc.ErrCode {
-			case -9999:
-				return json.Unmarshal(body, out)
-			case 0:
-				//fmt.Println(url, at)
-				return nil
-			case 42001:
-				continue
-			default:
-				return ewc
-			}
-		}
+//Call API once, the errcode of WeChat is returned as *ErrWeChat.
+func (w *WeChat) callOnce(ctx context.Context, method, url string, data []byte, out interface{}) error {
+	body, err := w.do(ctx, method, url, data)
+	if err != nil {
+		return err
 	}
-	return ewc
+	return decodeResponse(body, out)
 }
@@ -1,6 +1,7 @@
 package wechat
 
 import (
+	"context"
 	"testing"
 )
 
@@ -10,6 +11,6 @@ func TestDef(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	} else {
-		t.Log(wc.getAccessToken())
+		t.Log(wc.getAccessToken(context.Background()))
 	}
 }
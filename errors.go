@@ -0,0 +1,81 @@
+package wechat
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+//Logger of WeChat, *log.Logger implements it.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+//Logger writes to the standard logger of package log.
+type stdLogger struct{}
+
+func (stdLogger) Println(v ...interface{}) {
+	log.Println(v...)
+}
+
+//Logger which drops everything
+type discardLogger struct{}
+
+func (discardLogger) Println(v ...interface{}) {}
+
+//Handle error returned by handler or recovered from its panic.
+//recovered is the value passed to panic, nil if handler returned err.
+//The handler may send a fallback reply through RespondWriter.
+type ErrorHandler func(w RespondWriter, r *Request, err error, recovered interface{})
+
+//Report errors to logger, the default logger is the standard logger of package log.
+//Nil logger discards errors.
+func WithLogger(logger Logger) Option {
+	return func(w *WeChat) error {
+		if logger == nil {
+			logger = discardLogger{}
+		}
+		w.logger = logger
+		return nil
+	}
+}
+
+//Handle errors of handlers, such as replying an apology text.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(w *WeChat) error {
+		w.onError = handler
+		return nil
+	}
+}
+
+//Reply text when handler fails.
+func ReplyTextOnError(text string) ErrorHandler {
+	return func(w RespondWriter, r *Request, err error, recovered interface{}) {
+		w.ReplyText(text)
+	}
+}
+
+//Call handler, recover its panic and pass its error to error handler.
+func (wc *WeChat) callHandler(handler HandleFunc, resp *Respond, msg *Request) {
+	defer func() {
+		if p := recover(); p != nil {
+			wc.handleError(resp, msg, fmt.Errorf("wechat: handler panic: %v\n%s", p, debug.Stack()), p)
+		}
+	}()
+	if err := handler(resp, msg); err != nil {
+		wc.handleError(resp, msg, err, nil)
+	}
+}
+
+func (wc *WeChat) handleError(resp *Respond, msg *Request, err error, recovered interface{}) {
+	wc.logger.Println(err)
+	if wc.onError == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			wc.logger.Println("wechat: error handler panic:", p)
+		}
+	}()
+	wc.onError(resp, msg, err, recovered)
+}
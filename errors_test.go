@@ -0,0 +1,65 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testLogger []string
+
+func (l *testLogger) Println(v ...interface{}) {
+	*l = append(*l, fmt.Sprint(v...))
+}
+
+func TestErrorHandler(t *testing.T) {
+	logger := &testLogger{}
+	var recovered interface{}
+	wc, err := New(&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}},
+		WithLogger(logger),
+		WithErrorHandler(func(w RespondWriter, r *Request, err error, p interface{}) {
+			recovered = p
+			w.ReplyText("sorry")
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		switch r.Content {
+		case "panic":
+			panic("boom")
+		case "error":
+			return errors.New("db down")
+		}
+		return nil
+	}, MsgTypeText)
+
+	rec := testServe(wc, testText("error", "1"))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "[sorry]") {
+		t.Error(rec.Code, rec.Body.String())
+	}
+	if recovered != nil || len(*logger) == 0 || (*logger)[0] != "db down" {
+		t.Error(recovered, *logger)
+	}
+	rec = testServe(wc, testText("panic", "2"))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "[sorry]") {
+		t.Error(rec.Code, rec.Body.String())
+	}
+	if recovered != "boom" || !strings.Contains((*logger)[1], "boom") {
+		t.Error(recovered, *logger)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	wc, err := New(&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}}, WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		return errors.New("db down")
+	}, MsgTypeText)
+	if rec := testServe(wc, testText("error", "1")); rec.Code != 200 {
+		t.Error(rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,114 @@
+package wechat
+
+//Scan result of scancode_push and scancode_waitmsg events
+type ScanCodeInfo struct {
+	ScanType   string `json:",omitempty"`
+	ScanResult string `json:",omitempty"`
+}
+
+//Pictures of pic_sysphoto, pic_photo_or_album and pic_weixin events
+type SendPicsInfo struct {
+	Count   int       `json:",omitempty"`
+	PicList []PicItem `xml:"PicList>item" json:",omitempty"`
+}
+
+//Picture sent by user
+type PicItem struct {
+	PicMd5Sum string
+}
+
+//Location of location_select event
+type SendLocationInfo struct {
+	LocationX float64 `json:"Location_X,omitempty" xml:"Location_X"`
+	LocationY float64 `json:"Location_Y,omitempty" xml:"Location_Y"`
+	Scale     int     `json:",omitempty"`
+	Label     string  `json:",omitempty"`
+	Poiname   string  `json:",omitempty"`
+}
+
+//Result of mass message, pushed by MASSSENDJOBFINISH event
+type MassSendJobFinish struct {
+	MsgID       int64
+	Status      string // "send success" or "err(num)"
+	TotalCount  int    // Count of fans
+	FilterCount int    // Count of fans to send, after filtering
+	SentCount   int    // Count of fans sent successfully
+	ErrorCount  int    // Count of fans failed to send
+}
+
+//Result of template message, pushed by TEMPLATESENDJOBFINISH event
+type TemplateSendJobFinish struct {
+	MsgID  int64
+	Status string // "success", "failed:user block" or "failed: system failed"
+}
+
+//Check whether request is the event
+func (r *Request) isEvent(events ...string) bool {
+	if r.MsgType != msgEvent {
+		return false
+	}
+	for _, e := range events {
+		if r.Event == e {
+			return true
+		}
+	}
+	return false
+}
+
+//Scan result of scancode_push or scancode_waitmsg event, nil for other requests.
+func (r *Request) ScanCode() *ScanCodeInfo {
+	if !r.isEvent(EventScanCodePush, EventScanCodeWaitMsg) {
+		return nil
+	}
+	return r.ScanCodeInfo
+}
+
+//Pictures of pic_sysphoto, pic_photo_or_album or pic_weixin event, nil for other requests.
+func (r *Request) SendPics() *SendPicsInfo {
+	if !r.isEvent(EventPicSysPhoto, EventPicPhotoOrAlbum, EventPicWeixin) {
+		return nil
+	}
+	return r.SendPicsInfo
+}
+
+//Location of location_select event, nil for other requests.
+func (r *Request) SendLocation() *SendLocationInfo {
+	if !r.isEvent(EventLocationSelect) {
+		return nil
+	}
+	return r.SendLocationInfo
+}
+
+//Result of MASSSENDJOBFINISH event, nil for other requests.
+func (r *Request) MassSendJobFinish() *MassSendJobFinish {
+	if !r.isEvent(EventMassSendJobFinish) {
+		return nil
+	}
+	return &MassSendJobFinish{
+		MsgID:       r.MsgID,
+		Status:      r.Status,
+		TotalCount:  r.TotalCount,
+		FilterCount: r.FilterCount,
+		SentCount:   r.SentCount,
+		ErrorCount:  r.ErrorCount,
+	}
+}
+
+//Result of TEMPLATESENDJOBFINISH event, nil for other requests.
+func (r *Request) TemplateSendJobFinish() *TemplateSendJobFinish {
+	if !r.isEvent(EventTemplateSendJobFinish) {
+		return nil
+	}
+	return &TemplateSendJobFinish{
+		MsgID:  r.MsgID,
+		Status: r.Status,
+	}
+}
+
+//Media of shortvideo message, ok is false for other requests.
+func (r *Request) ShortVideo() (mediaId, thumbMediaId string, ok bool) {
+	if r.MsgType != MsgTypeShortVideo {
+		return "", "", false
+	}
+	return r.MediaId, r.ThumbMediaId, true
+}
@@ -0,0 +1,141 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"labix.org/v2/mgo/bson"
+)
+
+func TestEvents(t *testing.T) {
+	parse := func(data string) *Request {
+		r := &Request{}
+		if err := xml.Unmarshal([]byte(data), r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	r := parse(`<xml><ToUserName><![CDATA[gh_1]]></ToUserName><FromUserName><![CDATA[user]]></FromUserName><CreateTime>1408090502</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[scancode_push]]></Event><EventKey><![CDATA[6]]></EventKey><ScanCodeInfo><ScanType><![CDATA[qrcode]]></ScanType><ScanResult><![CDATA[1]]></ScanResult></ScanCodeInfo></xml>`)
+	if sc := r.ScanCode(); sc == nil || sc.ScanType != "qrcode" || sc.ScanResult != "1" {
+		t.Error(sc)
+	}
+	if r.SendPics() != nil || r.MassSendJobFinish() != nil {
+		t.Error("wrong event accessor")
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[pic_weixin]]></Event><SendPicsInfo><Count>2</Count><PicList><item><PicMd5Sum><![CDATA[a]]></PicMd5Sum></item><item><PicMd5Sum><![CDATA[b]]></PicMd5Sum></item></PicList></SendPicsInfo></xml>`)
+	if sp := r.SendPics(); sp == nil || sp.Count != 2 || len(sp.PicList) != 2 || sp.PicList[1].PicMd5Sum != "b" {
+		t.Error(sp)
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[location_select]]></Event><SendLocationInfo><Location_X><![CDATA[23]]></Location_X><Location_Y><![CDATA[113]]></Location_Y><Scale><![CDATA[15]]></Scale><Label><![CDATA[广州]]></Label><Poiname><![CDATA[]]></Poiname></SendLocationInfo></xml>`)
+	if sl := r.SendLocation(); sl == nil || sl.LocationX != 23 || sl.LocationY != 113 || sl.Scale != 15 || sl.Label != "广州" {
+		t.Error(sl)
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[MASSSENDJOBFINISH]]></Event><MsgID>1988</MsgID><Status><![CDATA[send success]]></Status><TotalCount>100</TotalCount><FilterCount>80</FilterCount><SentCount>75</SentCount><ErrorCount>5</ErrorCount></xml>`)
+	if m := r.MassSendJobFinish(); m == nil || m.MsgID != 1988 || m.SentCount != 75 || m.ErrorCount != 5 {
+		t.Error(m)
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[TEMPLATESENDJOBFINISH]]></Event><MsgID>200163836</MsgID><Status><![CDATA[success]]></Status></xml>`)
+	if m := r.TemplateSendJobFinish(); m == nil || m.MsgID != 200163836 || m.Status != "success" {
+		t.Error(m)
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[location]]></MsgType><Location_X>23.134521</Location_X><Location_Y>113.358803</Location_Y></xml>`)
+	if r.LocationX == 0 || r.LocationY == 0 {
+		t.Error(r.LocationX, r.LocationY)
+	}
+
+	r = parse(`<xml><MsgType><![CDATA[shortvideo]]></MsgType><MediaId><![CDATA[m]]></MediaId><ThumbMediaId><![CDATA[t]]></ThumbMediaId></xml>`)
+	if media, thumb, ok := r.ShortVideo(); !ok || media != "m" || thumb != "t" {
+		t.Error(media, thumb)
+	}
+}
+
+func TestShortVideoRoute(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		got = "video"
+		return nil
+	}, MsgTypeVideo)
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		got = "shortvideo"
+		return nil
+	}, MsgTypeShortVideo)
+	testServe(wc, `<xml><FromUserName>user</FromUserName><MsgType>shortvideo</MsgType><MsgId>1</MsgId></xml>`)
+	if got != "shortvideo" {
+		t.Error(got)
+	}
+}
+
+func TestRequestBSON(t *testing.T) {
+	r := &Request{MsgId: 1, MsgID: 2, Event: EventMassSendJobFinish}
+	data, err := bson.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &Request{}
+	if err := bson.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.MsgId != 1 || out.MsgID != 2 || out.Event != EventMassSendJobFinish {
+		t.Error(out)
+	}
+}
+
+type chanLogger chan string
+
+func (l chanLogger) Println(v ...interface{}) {
+	l <- fmt.Sprint(v...)
+}
+
+func TestTemplateSendCallbackPanic(t *testing.T) {
+	logger := make(chanLogger, 1)
+	wc, err := New(&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}}, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.OnTemplateSendJobFinish(func(job *TemplateSendJobFinish) {
+		panic("boom")
+	})
+	rec := testServe(wc, `<xml><FromUserName>user</FromUserName><MsgType>event</MsgType><Event>TEMPLATESENDJOBFINISH</Event><MsgID>1</MsgID><Status>success</Status></xml>`)
+	if rec.Code != 200 {
+		t.Error(rec.Code, rec.Body.String())
+	}
+	select {
+	case msg := <-logger:
+		if !strings.Contains(msg, "boom") {
+			t.Error(msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("panic of callback not logged")
+	}
+}
+
+func TestTemplateSendCallbackWhileServing(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			wc.OnTemplateSendJobFinish(func(job *TemplateSendJobFinish) {})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		testServe(wc, `<xml><FromUserName>user</FromUserName><CreateTime>`+strconv.Itoa(i)+`</CreateTime><MsgType>event</MsgType><Event>TEMPLATESENDJOBFINISH</Event><MsgID>1</MsgID><Status>success</Status></xml>`)
+	}
+	<-done
+}
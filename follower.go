@@ -0,0 +1,186 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+//Limits of batch user info
+const (
+	BatchGetUserLimit       = 100 // Users of each batchget call
+	DefaultBatchConcurrency = 4   // Concurrent batchget calls of StreamUsers
+)
+
+var ErrTooManyUsers = errors.New("wechat: too many users in batch")
+
+//Get information of users, at most BatchGetUserLimit users each time.
+func (w *WeChat) BatchGetUsers(openids []string, lang string) ([]*User, error) {
+	return w.BatchGetUsersContext(context.Background(), openids, lang)
+}
+
+//Get information of users with context
+func (w *WeChat) BatchGetUsersContext(ctx context.Context, openids []string, lang string) ([]*User, error) {
+	if len(openids) > BatchGetUserLimit {
+		return nil, ErrTooManyUsers
+	}
+	if lang == "" {
+		lang = LANG_CN
+	}
+	type item struct {
+		Openid string `json:"openid"`
+		Lang   string `json:"lang"`
+	}
+	list := make([]item, len(openids))
+	for i, id := range openids {
+		list[i] = item{id, lang}
+	}
+	var res struct {
+		Users []*User `json:"user_info_list"`
+	}
+	err := w.postJSONIdempotent(ctx, WeChatUserBatchGet, map[string][]item{"user_list": list}, &res)
+	return res.Users, err
+}
+
+//Iterator over pages of followers
+//
+//	it := wc.Followers(ctx)
+//	for it.Next() {
+//		ids := it.Page()
+//	}
+//	err := it.Err()
+type FollowerIterator struct {
+	wechat *WeChat
+	ctx    context.Context
+	next   string
+	page   []string
+	total  int
+	err    error
+	done   bool
+}
+
+//Walk all followers page by page.
+func (w *WeChat) Followers(ctx context.Context) *FollowerIterator {
+	return &FollowerIterator{wechat: w, ctx: ctx}
+}
+
+//Fetch the next page, false at the end or on error.
+func (it *FollowerIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	it.total, it.page, it.next, it.err = it.wechat.getUserPage(it.ctx, it.next)
+	if it.err != nil || len(it.page) == 0 {
+		it.done = true
+		it.page = nil
+		return false
+	}
+	if it.next == "" {
+		// Deliver this page, then stop
+		it.done = true
+	}
+	return true
+}
+
+//Openids of current page
+func (it *FollowerIterator) Page() []string {
+	return it.page
+}
+
+//Count of all followers
+func (it *FollowerIterator) Total() int {
+	return it.total
+}
+
+//Error stopped the iterator
+func (it *FollowerIterator) Err() error {
+	return it.err
+}
+
+//Stream information of all followers, fetched by batchget with at most
+//concurrency calls at the same time. Users are not in order. The error
+//channel gets the first error, or is closed after users are all sent.
+//Callers must drain users or cancel ctx, otherwise the workers block.
+//
+//	users, errs := wc.StreamUsers(ctx, wechat.LANG_CN, 0)
+//	for u := range users {
+//	}
+//	err := <-errs
+func (w *WeChat) StreamUsers(ctx context.Context, lang string, concurrency int) (<-chan *User, <-chan error) {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	users := make(chan *User, BatchGetUserLimit)
+	errs := make(chan error, 1)
+	batches := make(chan []string)
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			// Calls fail with wrapped error after ctx of caller is done
+			if perr := parent.Err(); perr != nil {
+				err = perr
+			}
+			errs <- err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(batches)
+		it := w.Followers(ctx)
+		for it.Next() {
+			page := it.Page()
+			for len(page) > 0 {
+				n := len(page)
+				if n > BatchGetUserLimit {
+					n = BatchGetUserLimit
+				}
+				select {
+				case batches <- page[:n]:
+				case <-ctx.Done():
+					return
+				}
+				page = page[n:]
+			}
+		}
+		if err := it.Err(); err != nil {
+			fail(err)
+		}
+	}()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				list, err := w.BatchGetUsersContext(ctx, batch, lang)
+				if err != nil {
+					fail(err)
+					return
+				}
+				for _, u := range list {
+					select {
+					case users <- u:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			fail(err)
+		}
+		cancel()
+		close(users)
+		close(errs)
+	}()
+	return users, errs
+}
@@ -1,13 +1,99 @@
 package wechat
 
+import (
+	"context"
+)
+
 type Group struct {
-	Id   int
-	Name string
+	Id    int
+	Name  string
+	Count int `json:",omitempty"` // Count of members
 }
 
 //Create a new Group
 func (w *WeChat) CreateGroup(name string) (Group, error) {
+	return w.CreateGroupContext(context.Background(), name)
+}
+
+//Create a new Group with context
+func (w *WeChat) CreateGroupContext(ctx context.Context, name string) (Group, error) {
 	g := map[string]Group{}
-	err := w.post(WeChatGroupCreate, []byte(`{"group":{"name":"`+name+`"}}`), &g)
+	err := w.postJSON(ctx, WeChatGroupCreate, map[string]map[string]string{"group": {"name": name}}, &g)
 	return g["group"], err
 }
+
+//Get all groups
+func (w *WeChat) GetGroups() ([]Group, error) {
+	return w.GetGroupsContext(context.Background())
+}
+
+//Get all groups with context
+func (w *WeChat) GetGroupsContext(ctx context.Context) ([]Group, error) {
+	g := map[string][]Group{}
+	err := w.get(ctx, WeChatGroupGet, &g, true)
+	return g["groups"], err
+}
+
+//Rename group
+func (w *WeChat) UpdateGroup(id int, name string) error {
+	return w.UpdateGroupContext(context.Background(), id, name)
+}
+
+//Rename group with context
+func (w *WeChat) UpdateGroupContext(ctx context.Context, id int, name string) error {
+	return w.postJSONIdempotent(ctx, WeChatGroupUpdate, map[string]interface{}{
+		"group": map[string]interface{}{"id": id, "name": name},
+	}, nil)
+}
+
+//Delete group, its members are moved to the default group.
+func (w *WeChat) DeleteGroup(id int) error {
+	return w.DeleteGroupContext(context.Background(), id)
+}
+
+//Delete group with context
+func (w *WeChat) DeleteGroupContext(ctx context.Context, id int) error {
+	return w.postJSONIdempotent(ctx, WeChatGroupDelete, map[string]interface{}{
+		"group": map[string]int{"id": id},
+	}, nil)
+}
+
+//Move user to group
+func (w *WeChat) MoveUserToGroup(openid string, groupId int) error {
+	return w.MoveUserToGroupContext(context.Background(), openid, groupId)
+}
+
+//Move user to group with context
+func (w *WeChat) MoveUserToGroupContext(ctx context.Context, openid string, groupId int) error {
+	return w.postJSONIdempotent(ctx, WeChatGroupMemberUpdate, map[string]interface{}{
+		"openid":     openid,
+		"to_groupid": groupId,
+	}, nil)
+}
+
+//Move users to group, at most 50 users each time.
+func (w *WeChat) MoveUsersToGroup(openids []string, groupId int) error {
+	return w.MoveUsersToGroupContext(context.Background(), openids, groupId)
+}
+
+//Move users to group with context
+func (w *WeChat) MoveUsersToGroupContext(ctx context.Context, openids []string, groupId int) error {
+	return w.postJSONIdempotent(ctx, WeChatGroupMemberBatchUpdate, map[string]interface{}{
+		"openid_list": openids,
+		"to_groupid":  groupId,
+	}, nil)
+}
+
+//Get group id of user
+func (w *WeChat) GetUserGroup(openid string) (int, error) {
+	return w.GetUserGroupContext(context.Background(), openid)
+}
+
+//Get group id of user with context
+func (w *WeChat) GetUserGroupContext(ctx context.Context, openid string) (int, error) {
+	var res struct {
+		GroupId int `json:"groupid"`
+	}
+	err := w.postJSONIdempotent(ctx, WeChatGroupGetIdByUser, map[string]string{"openid": openid}, &res)
+	return res.GroupId, err
+}
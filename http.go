@@ -1,37 +1,49 @@
 package wechat
 
 import (
-	"crypto/sha1"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"sort"
+	"sync"
 	"time"
 )
 
 //Check valid from wechat.
 func checkSignature(token string, w http.ResponseWriter, r *http.Request) bool {
 	r.ParseForm()
-	var signature string = r.FormValue("signature")
-	var timestamp string = r.FormValue("timestamp")
-	var nonce string = r.FormValue("nonce")
-	strs := sort.StringSlice{token, timestamp, nonce}
-	sort.Strings(strs)
-	var str string
-	for _, s := range strs {
-		str += s
+	return equalSignature(Signature(token, r.FormValue("timestamp"), r.FormValue("nonce")), r.FormValue("signature"))
+}
+
+//Read message body, decrypt it if it is encrypted.
+func (wc *WeChat) readMessage(r *http.Request) (data []byte, encrypted bool, err error) {
+	data, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.FormValue("encrypt_type") != "aes" || wc.mode == EncodingPlain {
+		if wc.mode == EncodingSafe {
+			return nil, false, ErrInvalidEncrypted
+		}
+		return data, false, nil
+	}
+	env := &encryptedRequest{}
+	if err := xml.Unmarshal(data, env); err != nil {
+		return nil, false, err
 	}
-	h := sha1.New()
-	h.Write([]byte(str))
-	return fmt.Sprintf("%x", h.Sum(nil)) == signature
+	if !equalSignature(Signature(wc.token, r.FormValue("timestamp"), r.FormValue("nonce"), env.Encrypt), r.FormValue("msg_signature")) {
+		return nil, false, ErrInvalidEncrypted
+	}
+	data, err = wc.crypt.Decrypt(env.Encrypt)
+	return data, true, err
 }
 
 //Handle http request
 //implement the http.Handler interface
 func (wc *WeChat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !checkSignature(wc.token, w, r) {
+	start := time.Now()
+	if !checkSignature(wc.token, w, r) || !wc.checkTimestamp(r) {
 		http.Error(w, "", http.StatusUnauthorized)
 		return
 	}
@@ -41,8 +53,9 @@ func (wc *WeChat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Read message
-	data, err := ioutil.ReadAll(r.Body)
+	data, encrypted, err := wc.readMessage(r)
 	if err != nil {
+		wc.logger.Println(err)
 		http.Error(w, "", http.StatusBadRequest)
 		return
 	}
@@ -50,29 +63,89 @@ func (wc *WeChat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//Process Message
 	msg := &Request{}
 	if err := xml.Unmarshal(data, &msg); err != nil {
-		log.Println(err)
+		wc.logger.Println(err)
 		http.Error(w, "", http.StatusBadRequest)
 		return
 	}
+	ok, retry := wc.checkNonce(r, msg)
+	if !ok {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	//Handlers must reply within 5 seconds, in async mode they may run longer.
+	if wc.asyncDeadline > 0 {
+		msg.ctx = context.WithoutCancel(r.Context())
+	} else {
+		ctx, cancel := context.WithDeadline(r.Context(), start.Add(ReplyTimeout))
+		defer cancel()
+		msg.ctx = ctx
+	}
+	resp := &Respond{
+		wechat:       wc,
+		Writer:       w,
+		ToUserName:   msg.FromUserName,
+		FromUserName: msg.ToUserName,
+		encrypted:    encrypted,
+		timestamp:    r.FormValue("timestamp"),
+		nonce:        r.FormValue("nonce"),
+	}
+	//Deduplicate retried message
+	key := msg.dedupKey()
+	ds, dedup := wc.atrw.(DedupStorage)
+	if dedup {
+		first, err := ds.MarkMessage(key)
+		if err != nil {
+			wc.logger.Println(err)
+			dedup = false
+		} else if !first {
+			resp.out, _ = waitReply(ds, key)
+			resp.flush()
+			return
+		}
+	}
+	if retry {
+		// Nonce was used by the message, but it is no longer remembered
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
 	// Storage every valid request
 	go wc.atrw.SaveRequest(msg)
+	wc.finishJob(msg)
+	wc.syncUserEvent(msg)
+	if wc.asyncDeadline > 0 {
+		wc.handleAsync(resp, msg)
+	} else {
+		wc.handle(resp, msg)
+	}
+	if dedup {
+		if err := ds.SaveMessageReply(key, resp.out); err != nil {
+			wc.logger.Println(err)
+		}
+	}
+	resp.flush()
+}
+
+//Find route of request and call its handler.
+func (wc *WeChat) handle(resp *Respond, msg *Request) {
 	requestPath := msg.MsgType
 	if requestPath == msgEvent {
 		requestPath += "." + msg.Event
 	}
-	for _, route := range wc.routes {
-		if !route.Regex.MatchString(requestPath) {
-			continue
+	wc.routeMu.RLock()
+	route := wc.fallback
+	for _, r := range wc.routes {
+		if r.match(requestPath, msg) {
+			route = r
+			break
 		}
-		route.Handle(&Respond{
-			wechat:       wc,
-			Writer:       w,
-			ToUserName:   msg.FromUserName,
-			FromUserName: msg.ToUserName,
-		}, msg)
+	}
+	middlewares := wc.middlewares
+	wc.routeMu.RUnlock()
+	if route == nil {
 		return
-
 	}
+	handler := Chain(Chain(route.Handle, route.Middlewares...), middlewares...)
+	wc.callHandler(handler, resp, msg)
 }
 
 //Respond to wechat server
@@ -81,34 +154,68 @@ type Respond struct {
 	Writer       http.ResponseWriter
 	ToUserName   string
 	FromUserName string
+	encrypted    bool   // Reply in encrypted envelope
+	timestamp    string // Timestamp of request, used by encrypted envelope
+	nonce        string // Nonce of request, used by encrypted envelope
+	out          string // Reply message, written after handler returns
+	mu           sync.Mutex
+	detached     bool // Passive reply timed out, reply through customer-service API
 }
 
 func (r *Respond) ReplyText(text string) {
-	r.reply(
-		`<MsgType><![CDATA[text]]></MsgType><Content><![CDATA[` + text + `]]></Content>`)
+	r.reply("text", newTextReply(text),
+		func() error { return r.wechat.PostText(r.ToUserName, text) })
 }
 
-func (r *Respond) reply(message string) {
-	head := fmt.Sprintf(`<xml><ToUserName><![CDATA[%s]]></ToUserName>
-<FromUserName><![CDATA[%s]]></FromUserName>
-<CreateTime>%d</CreateTime>%v</xml>`, r.ToUserName, r.FromUserName, time.Now().Unix(), message)
+//Reply message passively, or post it through customer-service API
+//if the passive reply timed out in async mode.
+func (r *Respond) reply(msgType string, msg replyMessage, post func() error) {
+	r.mu.Lock()
+	if r.detached {
+		r.mu.Unlock()
+		if err := post(); err != nil {
+			r.wechat.logger.Println(err)
+		}
+		return
+	}
+	defer r.mu.Unlock()
+	head, err := marshalReply(msg, msgType, r.ToUserName, r.FromUserName, time.Now().Unix())
+	if err != nil {
+		r.wechat.logger.Println(err)
+		return
+	}
 	go r.wechat.atrw.SaveReply(head)
-	r.Writer.Write([]byte(head))
+	r.out = head
+}
+
+//Write reply to wechat server
+func (r *Respond) flush() {
+	if r.out == "" {
+		return
+	}
+	data := []byte(r.out)
+	if r.encrypted && r.out != replySuccess {
+		var err error
+		if data, err = r.wechat.crypt.EncryptReply(r.wechat.token, r.timestamp, r.nonce, data); err != nil {
+			r.wechat.logger.Println(err)
+			return
+		}
+	}
+	r.Writer.Write(data)
 }
 
 func (r *Respond) ReplyImage(mediaId string) {
-	r.reply(
-		`<![CDATA[image]]></MsgType><Image><MediaId><![CDATA[` + mediaId + `]]></MediaId></Image>`)
+	r.reply("image", newImageReply(mediaId),
+		func() error { return r.wechat.PostImage(r.ToUserName, mediaId) })
 }
 func (r *Respond) ReplyVoice(mediaId string) {
-	r.reply(
-		`<MsgType><![CDATA[voice]]></MsgType><Voice><MediaId><![CDATA[` + mediaId + `]]></MediaId></Voice>`)
+	r.reply("voice", newVoiceReply(mediaId),
+		func() error { return r.wechat.PostVoice(r.ToUserName, mediaId) })
 }
 
 func (r *Respond) ReplyVideo(mediaId, title, desp string) {
-	r.reply(
-		fmt.Sprintf(`<MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[%s]]></MediaId><Title><![CDATA[%s]]></Title><Description><![CDATA[%s]]></Description></Video>`,
-			mediaId, title, desp))
+	r.reply("video", newVideoReply(mediaId, title, desp),
+		func() error { return r.wechat.PostVideo(r.ToUserName, mediaId, title, desp) })
 }
 
 type Music struct {
@@ -120,9 +227,8 @@ type Music struct {
 }
 
 func (r *Respond) ReplyMusic(music *Music) {
-	r.reply(
-		fmt.Sprintf(`<MsgType><![CDATA[music]]></MsgType><Music><Title><![CDATA[%s]]></Title><Description><![CDATA[%s]]></Description><MusicUrl><![CDATA[%s]]></MusicUrl><HQMusicUrl><![CDATA[%s]]></HQMusicUrl><ThumbMediaId><![CDATA[%s]]></ThumbMediaId></Music>`,
-			music.Title, music.Description, music.MusicUrl, music.HQMusicUrl, music.ThumbMediaId))
+	r.reply("music", newMusicReply(music),
+		func() error { return r.wechat.PostMusic(r.ToUserName, *music) })
 }
 
 type Article struct {
@@ -133,15 +239,22 @@ type Article struct {
 }
 
 func (r *Respond) ReplyNews(articles []Article) {
-	ctx := ""
-	for _, article := range articles {
-		ctx += fmt.Sprintf(`<item><Title><![CDATA[%s]]></Title> <Description><![CDATA[%s]]></Description><PicUrl><![CDATA[%s]]></PicUrl><Url><![CDATA[%s]]></Url></item>`,
-			article.Title, article.Description, article.PicUrl, article.Url)
-	}
-	r.reply(
-		fmt.Sprintf(`<MsgType><![CDATA[news]]></MsgType><ArticleCount>%d</ArticleCount><Articles>%s</Articles>`,
-			len(articles), ctx))
+	r.reply("news", newNewsReply(articles),
+		func() error { return r.wechat.PostNews(r.ToUserName, articles) })
+}
+
+// Transfer the conversation to customer service, kfAccount is the account of
+// the agent, such as "test1@test". Empty kfAccount means any online agent.
+func (r *Respond) TransferCustomerService(kfAccount string) {
+	r.reply(replyTransferCustomerService, newTransferReply(kfAccount),
+		func() error { return ErrTransferTimeout })
 }
+
+//WeChat of the account which received the request
+func (r *Respond) WeChat() *WeChat {
+	return r.wechat
+}
+
 func (r *Respond) FromUserId() string {
 	return r.ToUserName
 }
@@ -155,6 +268,16 @@ type RespondWriter interface {
 	ReplyVideo(mediaId, title, description string) //Reply text message to wechat
 	ReplyMusic(music *Music)                       //Reply text message to wechat
 	ReplyNews(articles []Article)                  //Reply text message to wechat
+	TransferCustomerService(kfAccount string)      //Transfer conversation to customer service
+}
+
+//RespondWriter of the account which received the request, RespondWriter
+//passed to handlers implements it.
+//
+//	wc := w.(wechat.WeChatWriter).WeChat()
+type WeChatWriter interface {
+	RespondWriter
+	WeChat() *WeChat //WeChat of the account which received the request
 }
 
 type Request struct {
@@ -168,8 +291,8 @@ type Request struct {
 	MediaId      string  `json:",omitempty"`
 	Format       string  `json:",omitempty"`
 	ThumbMediaId string  `json:",omitempty"`
-	LocationX    float32 `json:"Location_X,omitempty",xml:"Location_X"`
-	LocationY    float32 `json:"Location_Y,omitempty",xml:"Location_Y"`
+	LocationX    float32 `json:"Location_X,omitempty" xml:"Location_X"`
+	LocationY    float32 `json:"Location_Y,omitempty" xml:"Location_Y"`
 	Scale        float32 `json:",omitempty"`
 	Label        string  `json:",omitempty"`
 	Title        string  `json:",omitempty"`
@@ -183,23 +306,58 @@ type Request struct {
 	Precision    float32 `json:",omitempty"`
 	Recognition  string  `json:",omitempty"`
 	UserName     string  `json:"-"`
+	// Menu events
+	ScanCodeInfo     *ScanCodeInfo     `json:",omitempty"`
+	SendPicsInfo     *SendPicsInfo     `json:",omitempty"`
+	SendLocationInfo *SendLocationInfo `json:",omitempty"`
+	// Job finish events, MsgID is the id of mass or template message.
+	// bson lowercases keys, so MsgID needs a key distinct from MsgId.
+	MsgID       int64  `json:",omitempty" bson:"jobmsgid,omitempty"`
+	Status      string `json:",omitempty"`
+	TotalCount  int    `json:",omitempty"`
+	FilterCount int    `json:",omitempty"`
+	SentCount   int    `json:",omitempty"`
+	ErrorCount  int    `json:",omitempty"`
+	ctx         context.Context
+}
+
+//Context of request, it is derived from the inbound http request.
+//In sync mode its deadline is the 5 seconds budget of passive reply.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 const (
 	msgEvent = "event"
+	// MsgType of video message, MsgTypeVideo is the route of it
+	MsgVideo = "video"
 	// Event Type
 	EventSubscribe   = "subscribe"
 	EventUnsubscribe = "unsubscribe"
-	EventScan        = "scan"
+	EventScan        = "SCAN"
 	EventClick       = "CLICK"
 	EventLocation    = "LOCATION"
 	EventView        = "VIEW"
+	// Menu event type
+	EventScanCodePush    = "scancode_push"
+	EventScanCodeWaitMsg = "scancode_waitmsg"
+	EventPicSysPhoto     = "pic_sysphoto"
+	EventPicPhotoOrAlbum = "pic_photo_or_album"
+	EventPicWeixin       = "pic_weixin"
+	EventLocationSelect  = "location_select"
+	// Job finish event type
+	EventMassSendJobFinish     = "MASSSENDJOBFINISH"
+	EventTemplateSendJobFinish = "TEMPLATESENDJOBFINISH"
 	// Message type
 	MsgTypeDefault          = ".*"
 	MsgTypeText             = "text"
 	MsgTypeImage            = "image"
 	MsgTypeVoice            = "voice"
-	MsgTypeVideo            = "video"
+	MsgTypeVideo            = "^" + MsgVideo + "$" // Do not match shortvideo
+	MsgTypeShortVideo       = "shortvideo"
 	MsgTypeLocation         = "location"
 	MsgTypeLink             = "link"
 	MsgTypeEvent            = msgEvent + ".*"
@@ -209,12 +367,28 @@ const (
 	MsgTypeEventClick       = msgEvent + "\\." + EventClick
 	MsgTypeEventView        = msgEvent + "\\." + EventView
 	MsgTypeEventLocation    = msgEvent + "\\." + EventLocation
+	// Menu event route
+	MsgTypeEventScanCodePush    = msgEvent + "\\." + EventScanCodePush
+	MsgTypeEventScanCodeWaitMsg = msgEvent + "\\." + EventScanCodeWaitMsg
+	MsgTypeEventPicSysPhoto     = msgEvent + "\\." + EventPicSysPhoto
+	MsgTypeEventPicPhotoOrAlbum = msgEvent + "\\." + EventPicPhotoOrAlbum
+	MsgTypeEventPicWeixin       = msgEvent + "\\." + EventPicWeixin
+	MsgTypeEventLocationSelect  = msgEvent + "\\." + EventLocationSelect
+	// Job finish event route
+	MsgTypeEventMassSendJobFinish     = msgEvent + "\\." + EventMassSendJobFinish
+	MsgTypeEventTemplateSendJobFinish = msgEvent + "\\." + EventTemplateSendJobFinish
 	// Media type
 	MediaTypeImage = "image"
 	MediaTypeVoice = "voice"
 	MediaTypeVideo = "video"
 	MediaTypeThumb = "thumb"
 	// Button type
-	MenuButtonTypeKey = "click"
-	MenuButtonTypeUrl = "view"
+	MenuButtonTypeKey             = "click"
+	MenuButtonTypeUrl             = "view"
+	MenuButtonTypeScanCodePush    = EventScanCodePush
+	MenuButtonTypeScanCodeWaitMsg = EventScanCodeWaitMsg
+	MenuButtonTypePicSysPhoto     = EventPicSysPhoto
+	MenuButtonTypePicPhotoOrAlbum = EventPicPhotoOrAlbum
+	MenuButtonTypePicWeixin       = EventPicWeixin
+	MenuButtonTypeLocationSelect  = EventLocationSelect
 )
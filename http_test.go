@@ -0,0 +1,63 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Send a signed plaintext callback to wechat.
+func testServe(wc *WeChat, body string) *httptest.ResponseRecorder {
+	return testServeAt(wc, "1400000000", "abc", body)
+}
+
+//Send a signed plaintext callback with timestamp and nonce to wechat.
+func testServeAt(wc *WeChat, timestamp, nonce, body string) *httptest.ResponseRecorder {
+	q := url.Values{
+		"timestamp": {timestamp},
+		"nonce":     {nonce},
+		"signature": {Signature(wc.token, timestamp, nonce)},
+	}
+	rec := httptest.NewRecorder()
+	wc.ServeHTTP(rec, httptest.NewRequest("POST", "/?"+q.Encode(), strings.NewReader(body)))
+	return rec
+}
+
+//Plaintext text message from user
+func testText(content, msgId string) string {
+	return `<xml><ToUserName>gh_1</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content>` + content + `</Content><MsgId>` + msgId + `</MsgId></xml>`
+}
+
+func TestRequestContext(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deadline time.Time
+	wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+		deadline, _ = r.Context().Deadline()
+		return nil
+	}, MsgTypeText)
+	testServe(wc, testText("hi", "1"))
+	if left := time.Until(deadline); left <= 0 || left > ReplyTimeout {
+		t.Error(deadline)
+	}
+}
+
+func TestAPIContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	wc, _ := NewWeChatInMem("wxappid", "secret", "token")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := wc.do(ctx, "GET", srv.URL, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,92 @@
+package wechat
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//Results of jobs are kept this long for waiters
+const jobExpire = 24 * time.Hour
+
+//Jobs of mass messages, finished by events pushed to ServeHTTP.
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+type job struct {
+	done    chan struct{}
+	req     *Request // Event finishing the job
+	created time.Time
+}
+
+//Get job of key, create it if it does not exist.
+func (t *jobTracker) get(key string) *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.jobs == nil {
+		t.jobs = map[string]*job{}
+	}
+	j, ok := t.jobs[key]
+	if !ok {
+		now := time.Now()
+		for k, old := range t.jobs {
+			if now.Sub(old.created) > jobExpire {
+				delete(t.jobs, k)
+			}
+		}
+		j = &job{done: make(chan struct{}), created: now}
+		t.jobs[key] = j
+	}
+	return j
+}
+
+//Finish job of key with event.
+func (t *jobTracker) finish(key string, r *Request) {
+	j := t.get(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j.req == nil {
+		j.req = r
+		close(j.done)
+	}
+}
+
+//Wait for the event finishing job of key.
+func (t *jobTracker) wait(ctx context.Context, key string) (*Request, error) {
+	j := t.get(key)
+	select {
+	case <-j.done:
+		return j.req, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//Finish job of MASSSENDJOBFINISH event, or pass TEMPLATESENDJOBFINISH
+//event to callback.
+func (wc *WeChat) finishJob(r *Request) {
+	if r.isEvent(EventMassSendJobFinish) {
+		wc.jobs.finish(massJobKey(r.MsgID), r)
+	}
+	job := r.TemplateSendJobFinish()
+	if job == nil {
+		return
+	}
+	wc.routeMu.RLock()
+	callback := wc.onTemplateSend
+	wc.routeMu.RUnlock()
+	if callback == nil {
+		return
+	}
+	// Do not block or break the reply to WeChat server
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				wc.logger.Println("wechat: template send callback panic:", p)
+			}
+		}()
+		callback(job)
+	}()
+}
@@ -0,0 +1,78 @@
+package wechat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+//Lease of access token refresh, shared by instances using the same storage.
+//It is held for the retry budget of the fetch.
+const (
+	tokenLock     = "access_token"
+	tokenLockPoll = 100 * time.Millisecond
+)
+
+var ErrTokenLockTimeout = errors.New("wechat: timeout waiting for access token refresh of other instance")
+
+//Storage of leases, used to let only one instance refresh the access token.
+//Instances sharing a storage which implements it wait for the instance
+//holding the lease and read the new token with ReadAccessToken.
+type LockStorage interface {
+	AcquireLock(name, owner string, ttl time.Duration) (bool, error) // Hold lease of name for ttl, false if it is held by another owner
+	ReleaseLock(name, owner string) error                            // Release lease of name if it is held by owner
+}
+
+//Lease held by owner until expire
+type lease struct {
+	Name   string `bson:"_id"`
+	Owner  string
+	Expire time.Time
+}
+
+//Random owner of lease
+func newLockOwner() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+//Fetch access token holding the lease of storage, or wait for the
+//instance holding it. stale is the token stored when refresh started.
+func (w *WeChat) fetchAccessTokenLocked(ls LockStorage, stale AccessToken) (AccessToken, error) {
+	owner := newLockOwner()
+	ttl := w.retry.budget(DefaultAPITimeout)
+	deadline := time.Now().Add(2 * ttl)
+	for {
+		ok, err := ls.AcquireLock(tokenLock, owner, ttl)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		if at, refreshed := w.refreshedAccessToken(stale); refreshed {
+			// Refreshed by other instance
+			if ok {
+				w.releaseTokenLock(ls, owner)
+			}
+			return at, nil
+		}
+		if ok {
+			defer w.releaseTokenLock(ls, owner)
+			// The fetch must not outlive the lease
+			ctx, cancel := context.WithTimeout(context.Background(), ttl)
+			defer cancel()
+			return w.fetchAccessToken(ctx, stale)
+		}
+		if time.Now().After(deadline) {
+			return AccessToken{}, ErrTokenLockTimeout
+		}
+		time.Sleep(tokenLockPoll)
+	}
+}
+
+func (w *WeChat) releaseTokenLock(ls LockStorage, owner string) {
+	if err := ls.ReleaseLock(tokenLock, owner); err != nil {
+		w.logger.Println(err)
+	}
+}
@@ -0,0 +1,31 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemStorageLock(t *testing.T) {
+	s := NewMemStorage("wxappid", "secret", "token")
+	if ok, _ := s.AcquireLock("a", "one", time.Minute); !ok {
+		t.Fatal("lock not acquired")
+	}
+	if ok, _ := s.AcquireLock("a", "two", time.Minute); ok {
+		t.Error("lock held by other owner acquired")
+	}
+	if ok, _ := s.AcquireLock("a", "one", time.Minute); !ok {
+		t.Error("lock not renewed by owner")
+	}
+	s.ReleaseLock("a", "two")
+	if ok, _ := s.AcquireLock("a", "two", time.Minute); ok {
+		t.Error("lock released by other owner")
+	}
+	s.ReleaseLock("a", "one")
+	if ok, _ := s.AcquireLock("a", "two", time.Millisecond); !ok {
+		t.Error("released lock not acquired")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := s.AcquireLock("a", "one", time.Minute); !ok {
+		t.Error("expired lock not acquired")
+	}
+}
@@ -0,0 +1,218 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+//Message type of mass message
+const (
+	MassMsgTypeNews  = "mpnews"
+	MassMsgTypeText  = "text"
+	MassMsgTypeVoice = "voice"
+	MassMsgTypeImage = "image"
+	MassMsgTypeVideo = "mpvideo"
+	MassMsgTypeCard  = "wxcard"
+)
+
+//Status of mass message
+const (
+	MassStatusSending = "SENDING"
+	MassStatusSuccess = "SEND_SUCCESS"
+	MassStatusFail    = "SEND_FAIL"
+	MassStatusDelete  = "DELETE"
+)
+
+//Article of news uploaded for mass message
+type NewsArticle struct {
+	ThumbMediaId     string `json:"thumb_media_id"`
+	Author           string `json:"author,omitempty"`
+	Title            string `json:"title"`
+	ContentSourceUrl string `json:"content_source_url,omitempty"`
+	Content          string `json:"content"`
+	Digest           string `json:"digest,omitempty"`
+	ShowCoverPic     int    `json:"show_cover_pic"`
+}
+
+//Mass message
+type MassMessage struct {
+	MsgType           string // One of MassMsgType
+	Content           string // Content of text
+	MediaId           string // Media id of news, voice, image or video, card id of card
+	SendIgnoreReprint bool   // Send news even if it is judged as reprint
+}
+
+//Mass message accepted by WeChat server
+type MassResult struct {
+	MsgId     int64 `json:"msg_id"`
+	MsgDataId int64 `json:"msg_data_id"` // Data id of news, used by statistics
+}
+
+//Upload news used by mass message, return media id of news.
+func (w *WeChat) UploadNews(articles []NewsArticle) (mediaId string, createdAt time.Time, err error) {
+	return w.UploadNewsContext(context.Background(), articles)
+}
+
+//Upload news with context
+func (w *WeChat) UploadNewsContext(ctx context.Context, articles []NewsArticle) (mediaId string, createdAt time.Time, err error) {
+	data, err := json.Marshal(map[string][]NewsArticle{"articles": articles})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var res struct {
+		MediaId   string `json:"media_id"`
+		CreatedAt int64  `json:"created_at"`
+	}
+	if err := w.post(ctx, WeChatUpload, data, &res); err != nil {
+		return "", time.Time{}, err
+	}
+	return res.MediaId, time.Unix(res.CreatedAt, 0), nil
+}
+
+//Body of mass message sent to target
+func (m *MassMessage) body(target map[string]interface{}) ([]byte, error) {
+	body := map[string]interface{}{"msgtype": m.MsgType}
+	for k, v := range target {
+		body[k] = v
+	}
+	switch m.MsgType {
+	case MassMsgTypeText:
+		body[m.MsgType] = map[string]string{"content": m.Content}
+	case MassMsgTypeCard:
+		body[m.MsgType] = map[string]string{"card_id": m.MediaId}
+	default:
+		body[m.MsgType] = map[string]string{"media_id": m.MediaId}
+	}
+	if m.MsgType == MassMsgTypeNews {
+		ignore := 0
+		if m.SendIgnoreReprint {
+			ignore = 1
+		}
+		body["send_ignore_reprint"] = ignore
+	}
+	return json.Marshal(body)
+}
+
+//Send mass message to target, sending is not retried.
+func (w *WeChat) massSend(ctx context.Context, url string, msg *MassMessage, target map[string]interface{}) (*MassResult, error) {
+	data, err := msg.body(target)
+	if err != nil {
+		return nil, err
+	}
+	res := &MassResult{}
+	if err := w.post(ctx, url, data, res); err != nil {
+		return nil, err
+	}
+	// Track the job, so WaitMassSend gets the event of it
+	w.jobs.get(massJobKey(res.MsgId))
+	return res, nil
+}
+
+//Send mass message to all followers.
+func (w *WeChat) MassSendAll(msg *MassMessage) (*MassResult, error) {
+	return w.MassSendAllContext(context.Background(), msg)
+}
+
+//Send mass message to all followers with context
+func (w *WeChat) MassSendAllContext(ctx context.Context, msg *MassMessage) (*MassResult, error) {
+	return w.massSend(ctx, WeChatMassSendAll, msg, map[string]interface{}{
+		"filter": map[string]interface{}{"is_to_all": true},
+	})
+}
+
+//Send mass message to followers in group.
+func (w *WeChat) MassSendGroup(groupId int, msg *MassMessage) (*MassResult, error) {
+	return w.MassSendGroupContext(context.Background(), groupId, msg)
+}
+
+//Send mass message to followers in group with context
+func (w *WeChat) MassSendGroupContext(ctx context.Context, groupId int, msg *MassMessage) (*MassResult, error) {
+	return w.massSend(ctx, WeChatMassSendAll, msg, map[string]interface{}{
+		"filter": map[string]interface{}{"is_to_all": false, "group_id": strconv.Itoa(groupId)},
+	})
+}
+
+//Send mass message to followers with tag.
+func (w *WeChat) MassSendTag(tagId int, msg *MassMessage) (*MassResult, error) {
+	return w.MassSendTagContext(context.Background(), tagId, msg)
+}
+
+//Send mass message to followers with tag with context
+func (w *WeChat) MassSendTagContext(ctx context.Context, tagId int, msg *MassMessage) (*MassResult, error) {
+	return w.massSend(ctx, WeChatMassSendAll, msg, map[string]interface{}{
+		"filter": map[string]interface{}{"is_to_all": false, "tag_id": tagId},
+	})
+}
+
+//Send mass message to followers in openids, at least 2 and at most 10000.
+func (w *WeChat) MassSendOpenids(openids []string, msg *MassMessage) (*MassResult, error) {
+	return w.MassSendOpenidsContext(context.Background(), openids, msg)
+}
+
+//Send mass message to followers in openids with context
+func (w *WeChat) MassSendOpenidsContext(ctx context.Context, openids []string, msg *MassMessage) (*MassResult, error) {
+	return w.massSend(ctx, WeChatMassSend, msg, map[string]interface{}{"touser": openids})
+}
+
+//Preview mass message by sending it to one follower.
+func (w *WeChat) MassPreview(openid string, msg *MassMessage) error {
+	return w.MassPreviewContext(context.Background(), openid, msg)
+}
+
+//Preview mass message with context
+func (w *WeChat) MassPreviewContext(ctx context.Context, openid string, msg *MassMessage) error {
+	data, err := msg.body(map[string]interface{}{"touser": openid})
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, WeChatMassPreview, data, nil)
+}
+
+//Delete sent mass message, only news and video can be deleted.
+func (w *WeChat) MassDelete(msgId int64) error {
+	return w.MassDeleteContext(context.Background(), msgId)
+}
+
+//Delete sent mass message with context
+func (w *WeChat) MassDeleteContext(ctx context.Context, msgId int64) error {
+	data, err := json.Marshal(map[string]int64{"msg_id": msgId})
+	if err != nil {
+		return err
+	}
+	return w.postIdempotent(ctx, WeChatMassDelete, data, nil)
+}
+
+//Get status of mass message, one of MassStatus.
+func (w *WeChat) MassStatus(msgId int64) (string, error) {
+	return w.MassStatusContext(context.Background(), msgId)
+}
+
+//Get status of mass message with context
+func (w *WeChat) MassStatusContext(ctx context.Context, msgId int64) (string, error) {
+	data, err := json.Marshal(map[string]string{"msg_id": strconv.FormatInt(msgId, 10)})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Status string `json:"msg_status"`
+	}
+	err = w.postIdempotent(ctx, WeChatMassGet, data, &res)
+	return res.Status, err
+}
+
+func massJobKey(msgId int64) string {
+	return "mass:" + strconv.FormatInt(msgId, 10)
+}
+
+//Wait for MASSSENDJOBFINISH event of mass message pushed to ServeHTTP.
+//The event is pushed to the server configured for the account, so it may
+//be received by another instance.
+func (w *WeChat) WaitMassSend(ctx context.Context, msgId int64) (*MassSendJobFinish, error) {
+	r, err := w.jobs.wait(ctx, massJobKey(msgId))
+	if err != nil {
+		return nil, err
+	}
+	return r.MassSendJobFinish(), nil
+}
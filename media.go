@@ -0,0 +1,214 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Size limits of media, in bytes
+var MediaSizeLimit = map[string]int64{
+	MediaTypeImage: 10 << 20, // 10MB, bmp, png, jpeg, jpg or gif
+	MediaTypeVoice: 2 << 20,  // 2MB, amr or mp3, at most 60 seconds
+	MediaTypeVideo: 10 << 20, // 10MB, mp4
+	MediaTypeThumb: 64 << 10, // 64KB, jpg
+}
+
+var (
+	ErrMediaType     = errors.New("wechat: unknown media type")
+	ErrMediaTooLarge = errors.New("wechat: media exceeds size limit")
+)
+
+//Upload temporary media, which is kept for 3 days by WeChat server.
+//The media is streamed, so the upload is retried once after access token
+//is refreshed only if r is an io.Seeker, otherwise it is not retried.
+func (w *WeChat) UploadMedia(mediaType string, r io.Reader, filename string) (mediaId string, createdAt time.Time, err error) {
+	return w.UploadMediaContext(context.Background(), mediaType, r, filename)
+}
+
+//Upload temporary media with context
+func (w *WeChat) UploadMediaContext(ctx context.Context, mediaType string, r io.Reader, filename string) (mediaId string, createdAt time.Time, err error) {
+	limit, ok := MediaSizeLimit[mediaType]
+	if !ok {
+		return "", time.Time{}, ErrMediaType
+	}
+	if l, ok := r.(interface{ Len() int }); ok && int64(l.Len()) > limit {
+		return "", time.Time{}, ErrMediaTooLarge
+	}
+	// Upload from the current offset again if r can be rewound
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	var res struct {
+		MediaId      string `json:"media_id"`
+		ThumbMediaId string `json:"thumb_media_id"` // Thumb is returned as thumb_media_id
+		CreatedAt    int64  `json:"created_at"`
+	}
+	for retried := false; ; retried = true {
+		var at AccessToken
+		if at, err = w.getAccessToken(ctx); err != nil {
+			return "", time.Time{}, err
+		}
+		err = w.upload(ctx, fmt.Sprintf(WeChatMediaUpload, at.Token, mediaType), r, filename, limit, &res)
+		if _, refresh := w.retry.classify(err, true, false); !refresh {
+			break
+		}
+		w.invalidateAccessToken(at.Token)
+		if retried || seeker == nil {
+			break
+		}
+		if _, serr := seeker.Seek(start, io.SeekStart); serr != nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if res.MediaId == "" {
+		res.MediaId = res.ThumbMediaId
+	}
+	return res.MediaId, time.Unix(res.CreatedAt, 0), nil
+}
+
+//Stream media as multipart form, fail with ErrMediaTooLarge if it exceeds limit.
+func (w *WeChat) upload(ctx context.Context, url string, r io.Reader, filename string, limit int64, out interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultAPITimeout)
+		defer cancel()
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	errc := make(chan error, 1)
+	go func() {
+		part, err := mw.CreateFormFile("media", filename)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(part, io.LimitReader(r, limit+1))
+			if err == nil && n > limit {
+				err = ErrMediaTooLarge
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+	resp, err := w.open(ctx, "POST", url, pr, mw.FormDataContentType())
+	// Stop the writer if the body was not read to the end
+	pr.Close()
+	werr := <-errc
+	if werr == ErrMediaTooLarge {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return werr
+	}
+	// The writer fails with closed pipe if server answered before reading all
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		if err := decodeResponse(body, nil); err != nil {
+			return err
+		}
+		return werr
+	}
+	return decodeResponse(body, out)
+}
+
+//Download temporary media, the returned body must be closed.
+//Video is downloaded from the video_url returned by WeChat server.
+func (w *WeChat) DownloadMedia(mediaId string) (body io.ReadCloser, contentType string, err error) {
+	return w.DownloadMediaContext(context.Background(), mediaId)
+}
+
+//Download temporary media with context, ctx also bounds reading the body.
+func (w *WeChat) DownloadMediaContext(ctx context.Context, mediaId string) (body io.ReadCloser, contentType string, err error) {
+	id := strings.Replace(url.QueryEscape(mediaId), "%", "%%", -1)
+	err = w.retryCall(ctx, fmt.Sprintf(WeChatMediaGet, "%v", id), true, true, func(url string) error {
+		body, contentType, err = w.download(ctx, url)
+		return err
+	})
+	return body, contentType, err
+}
+
+func (w *WeChat) download(ctx context.Context, url string) (io.ReadCloser, string, error) {
+	cancel := func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, DefaultAPITimeout)
+	}
+	resp, err := w.open(ctx, "GET", url, nil, "")
+	if err != nil {
+		cancel()
+		return nil, "", err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if strings.Contains(contentType, "json") || strings.HasPrefix(contentType, "text/plain") {
+		// Error is returned as JSON, and so is video as the url of it
+		var video struct {
+			URL string `json:"video_url"`
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			err = decodeResponse(data, &video)
+		}
+		if err == nil && video.URL == "" {
+			err = fmt.Errorf("wechat: unexpected media response: %s", data)
+		}
+		if err == nil {
+			resp, err = w.open(ctx, "GET", video.URL, nil, "")
+		}
+		if err != nil {
+			cancel()
+			return nil, "", err
+		}
+		contentType = resp.Header.Get("Content-Type")
+	}
+	return &cancelBody{resp.Body, cancel}, contentType, nil
+}
+
+//Body which cancels its context when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+//Decode response of WeChat server, nonzero errcode is returned as error.
+func decodeResponse(body []byte, out interface{}) error {
+	ewc := &ErrWeChat{}
+	if err := json.Unmarshal(body, ewc); err != nil {
+		return err
+	}
+	if ewc.ErrCode != 0 {
+		return ewc
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
@@ -1,6 +1,7 @@
 package wechat
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
@@ -27,36 +28,56 @@ type MenuButton struct {
 
 // Create QR scene
 func (wc *WeChat) CreateQRScene(sceneId int, expires int) (*QRScene, error) {
+	return wc.CreateQRSceneContext(context.Background(), sceneId, expires)
+}
+
+// Create QR scene with context
+func (wc *WeChat) CreateQRSceneContext(ctx context.Context, sceneId int, expires int) (*QRScene, error) {
 	data := []byte(fmt.Sprintf(`{"expire_seconds":%d,"action_name":"QR_SCENE","action_info":{"scene":{"scene_id":%d}}}`, expires, sceneId))
 	var qr QRScene
-	err := wc.post(WeChatQRSceneCreate, data, &qr)
+	err := wc.post(ctx, WeChatQRSceneCreate, data, &qr)
 	return &qr, err
 }
 
 // Create  QR limit scene
 func (wc *WeChat) CreateQRLimitScene(sceneId int) (*QRScene, error) {
+	return wc.CreateQRLimitSceneContext(context.Background(), sceneId)
+}
+
+// Create QR limit scene with context
+func (wc *WeChat) CreateQRLimitSceneContext(ctx context.Context, sceneId int) (*QRScene, error) {
 	data := []byte(fmt.Sprintf(`{"action_name":"QR_LIMIT_SCENE","action_info":{"scene":{"scene_id":%d}}}`, sceneId))
 	var qr QRScene
-	err := wc.post(WeChatQRSceneCreate, data, &qr)
+	err := wc.post(ctx, WeChatQRSceneCreate, data, &qr)
 	return &qr, err
 }
 
 // Custom menu
 func (wc *WeChat) CreateMenu(menu *Menu) error {
+	return wc.CreateMenuContext(context.Background(), menu)
+}
+
+// Custom menu with context
+func (wc *WeChat) CreateMenuContext(ctx context.Context, menu *Menu) error {
 	if data, err := json.Marshal(menu); err != nil {
 		return err
 	} else {
 		//fmt.Println(string(data))
-		return wc.post(WeChatMenuCreate, data, nil)
+		return wc.postIdempotent(ctx, WeChatMenuCreate, data, nil)
 	}
 }
 
 func (wc *WeChat) GetMenu() (*Menu, error) {
+	return wc.GetMenuContext(context.Background())
+}
+
+// Get menu with context
+func (wc *WeChat) GetMenuContext(ctx context.Context) (*Menu, error) {
 	var result struct {
 		MenuCtx *Menu `json:"menu"`
 	}
 	result.MenuCtx = &Menu{}
-	err := wc.get(WeChatMenuGet, &result, true)
+	err := wc.get(ctx, WeChatMenuGet, &result, true)
 	if err != nil {
 		return nil, err
 	}
@@ -65,5 +86,10 @@ func (wc *WeChat) GetMenu() (*Menu, error) {
 
 // Delete Menu
 func (wc *WeChat) DeleteMenu() error {
-	return wc.get(WeChatMenuDelete, nil, true)
+	return wc.DeleteMenuContext(context.Background())
+}
+
+// Delete Menu with context
+func (wc *WeChat) DeleteMenuContext(ctx context.Context) error {
+	return wc.get(ctx, WeChatMenuDelete, nil, true)
 }
@@ -1,6 +1,7 @@
 package wechat
 
 import (
+	"context"
 	"testing"
 )
 
@@ -18,5 +19,5 @@ func TestMenu(t *testing.T) {
 		return
 	}
 	t.Log(m)
-	t.Log(wc.getAccessToken())
+	t.Log(wc.getAccessToken(context.Background()))
 }
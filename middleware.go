@@ -0,0 +1,22 @@
+package wechat
+
+//Middleware wraps HandleFunc to add cross-cutting logic, such as logging,
+//auth checks or rate limits. A middleware may short-circuit the request by
+//replying through RespondWriter without calling the next handler.
+type Middleware func(next HandleFunc) HandleFunc
+
+//Add middlewares to every route.
+//Middlewares run in the order they are added, before route middlewares.
+func (w *WeChat) Use(middlewares ...Middleware) {
+	w.routeMu.Lock()
+	defer w.routeMu.Unlock()
+	w.middlewares = append(w.middlewares, middlewares...)
+}
+
+//Wrap handler with middlewares, the first middleware is the outermost.
+func Chain(handler HandleFunc, middlewares ...Middleware) HandleFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,51 @@
+package wechat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(w RespondWriter, r *Request) error {
+				order = append(order, name)
+				return next(w, r)
+			}
+		}
+	}
+	auth := func(next HandleFunc) HandleFunc {
+		return func(w RespondWriter, r *Request) error {
+			if r.Content == "deny" {
+				w.ReplyText("denied")
+				return nil
+			}
+			return next(w, r)
+		}
+	}
+	wc.Use(mark("global1"), mark("global2"))
+	wc.RegisterRoute(MsgTypeText, func(w RespondWriter, r *Request) error {
+		order = append(order, "handler")
+		w.ReplyText("ok")
+		return nil
+	}, mark("route"), auth)
+
+	if reply := testServe(wc, testText("hi", "1")).Body.String(); !strings.Contains(reply, "[ok]") {
+		t.Error(reply)
+	}
+	if strings.Join(order, ",") != "global1,global2,route,handler" {
+		t.Error(order)
+	}
+	order = nil
+	if reply := testServe(wc, testText("deny", "2")).Body.String(); !strings.Contains(reply, "[denied]") {
+		t.Error(reply)
+	}
+	if strings.Join(order, ",") != "global1,global2,route" {
+		t.Error(order)
+	}
+}
@@ -167,3 +167,133 @@ func (s *MongoStorage) SetUserName(id, name, admin string) {
 		return err
 	})
 }
+
+func (m *MongoStorage) MarkMessage(key string) (bool, error) {
+	first := true
+	err := m.Query(func(d *mgo.Database) error {
+		c := d.C("message")
+		if err := c.EnsureIndex(mgo.Index{Key: []string{"time"}, ExpireAfter: DedupExpire}); err != nil {
+			return err
+		}
+		err := c.Insert(&message{Key: key, Time: time.Now()})
+		if mgo.IsDup(err) {
+			first = false
+			return nil
+		}
+		return err
+	})
+	return first, err
+}
+
+func (m *MongoStorage) SaveMessageReply(key, reply string) error {
+	return m.Query(func(d *mgo.Database) error {
+		return d.C("message").Update(bson.M{"_id": key},
+			bson.M{"$set": bson.M{"reply": reply, "done": true}})
+	})
+}
+
+func (m *MongoStorage) ReadMessageReply(key string) (string, bool, error) {
+	msg := message{}
+	err := m.Query(func(d *mgo.Database) error {
+		return d.C("message").FindId(key).One(&msg)
+	})
+	return msg.Reply, msg.Done, err
+}
+
+type nonce struct {
+	Nonce  string `bson:"_id"`
+	Expire time.Time
+}
+
+func (m *MongoStorage) UseNonce(n string, expire time.Time) (bool, error) {
+	fresh := true
+	err := m.Query(func(d *mgo.Database) error {
+		c := d.C("nonce")
+		if err := c.EnsureIndex(mgo.Index{Key: []string{"expire"}, ExpireAfter: time.Second}); err != nil {
+			return err
+		}
+		err := c.Insert(&nonce{Nonce: n, Expire: expire})
+		if mgo.IsDup(err) {
+			fresh = false
+			return nil
+		}
+		return err
+	})
+	return fresh, err
+}
+
+func (m *MongoStorage) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	acquired := true
+	err := m.Query(func(d *mgo.Database) error {
+		c := d.C("lock")
+		now := time.Now()
+		l := &lease{Name: name, Owner: owner, Expire: now.Add(ttl)}
+		// Take over the lease if it expired or is held by owner
+		err := c.Update(bson.M{
+			"_id": name,
+			"$or": []bson.M{{"expire": bson.M{"$lt": now}}, {"owner": owner}},
+		}, l)
+		if err != mgo.ErrNotFound {
+			return err
+		}
+		err = c.Insert(l)
+		if mgo.IsDup(err) {
+			acquired = false
+			return nil
+		}
+		return err
+	})
+	return acquired && err == nil, err
+}
+
+func (m *MongoStorage) ReleaseLock(name, owner string) error {
+	return m.Query(func(d *mgo.Database) error {
+		err := d.C("lock").Remove(bson.M{"_id": name, "owner": owner})
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (m *MongoStorage) SaveUser(u *User, synced time.Time) error {
+	return m.Query(func(d *mgo.Database) error {
+		// Upsert does not match the follower removed after synced, and
+		// inserting it again fails with duplicate key.
+		_, err := d.C("follower").Upsert(bson.M{"_id": u.Openid, "removed": bson.M{"$lte": synced}},
+			&follower{Openid: u.Openid, User: u, Synced: synced})
+		if mgo.IsDup(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (m *MongoStorage) RemoveUser(openid string) error {
+	return m.Query(func(d *mgo.Database) error {
+		now := time.Now()
+		_, err := d.C("follower").UpsertId(openid, &follower{Openid: openid, Synced: now, Removed: now})
+		return err
+	})
+}
+
+func (m *MongoStorage) RemoveUsersBefore(synced time.Time) error {
+	return m.Query(func(d *mgo.Database) error {
+		_, err := d.C("follower").RemoveAll(bson.M{"synced": bson.M{"$lt": synced}})
+		return err
+	})
+}
+
+func (m *MongoStorage) ReadUser(openid string) (*User, error) {
+	f := &follower{}
+	err := m.Query(func(d *mgo.Database) error {
+		return d.C("follower").FindId(openid).One(f)
+	})
+	if err == mgo.ErrNotFound || (err == nil && f.User == nil) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.User, nil
+}
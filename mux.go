@@ -0,0 +1,124 @@
+package wechat
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+)
+
+//Mux dispatches callbacks of several official accounts to their WeChat,
+//by the last segment of URL path or by ToUserName (original id of account).
+//Every WeChat keeps its own token, EncodingAESKey and Storage.
+type Mux struct {
+	mu          sync.RWMutex
+	byName      map[string]*WeChat
+	byId        map[string]*WeChat
+	accounts    []*WeChat
+	routes      []*Route
+	middlewares []Middleware
+}
+
+//Create mux of official accounts.
+func NewMux() *Mux {
+	return &Mux{
+		byName: map[string]*WeChat{},
+		byId:   map[string]*WeChat{},
+	}
+}
+
+//Add account to mux. Callbacks to URL ending with "/"+name, or sent to
+//originalId (such as "gh_123456789abc"), are handled by wc. Either of
+//name and originalId may be empty. Shared routes and middlewares of mux
+//are added to wc.
+func (m *Mux) Add(name, originalId string, wc *WeChat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name != "" {
+		m.byName[name] = wc
+	}
+	if originalId != "" {
+		m.byId[originalId] = wc
+	}
+	m.accounts = append(m.accounts, wc)
+	wc.Use(m.middlewares...)
+	for _, route := range m.routes {
+		wc.AddRoute(route)
+	}
+}
+
+//Get account by name or original id.
+func (m *Mux) Get(nameOrId string) *WeChat {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if wc, ok := m.byName[nameOrId]; ok {
+		return wc
+	}
+	return m.byId[nameOrId]
+}
+
+//Register handler shared by every account.
+//Use WeChatWriter to call the API of the account in handler.
+func (m *Mux) RegisterHandler(handler HandleFunc, patterns ...string) {
+	for _, pattern := range patterns {
+		m.AddRoute(&Route{Regex: regexp.MustCompile(pattern), Handle: handler})
+	}
+}
+
+//Add route shared by every account.
+func (m *Mux) AddRoute(route *Route) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, route)
+	for _, wc := range m.accounts {
+		wc.AddRoute(route)
+	}
+}
+
+//Add middlewares shared by every account.
+func (m *Mux) Use(middlewares ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.middlewares = append(m.middlewares, middlewares...)
+	for _, wc := range m.accounts {
+		wc.Use(middlewares...)
+	}
+}
+
+//Handle http request
+//implement the http.Handler interface
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if wc := m.Get(path.Base(r.URL.Path)); wc != nil {
+		wc.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	// ToUserName is plaintext in both plain and encrypted messages
+	var to struct {
+		ToUserName string
+	}
+	if err := xml.Unmarshal(data, &to); err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	m.mu.RLock()
+	wc := m.byId[to.ToUserName]
+	m.mu.RUnlock()
+	if wc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	wc.ServeHTTP(w, r)
+}
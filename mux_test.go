@@ -0,0 +1,71 @@
+package wechat
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	a, _ := NewWeChatInMem("wxa", "secret", "tokena")
+	b, _ := NewWeChatInMem("wxb", "secret", "tokenb")
+	m := NewMux()
+	m.Add("a", "gh_a", a)
+	m.Add("b", "gh_b", b)
+	m.RegisterHandler(func(w RespondWriter, r *Request) error {
+		w.ReplyText("shared:" + w.(WeChatWriter).WeChat().appid)
+		return nil
+	}, MsgTypeText)
+	b.RegisterKeyword(func(w RespondWriter, r *Request) error {
+		w.ReplyText("only b")
+		return nil
+	}, "b")
+
+	serve := func(target, token, to, content, id string) *httptest.ResponseRecorder {
+		q := url.Values{
+			"timestamp": {"1400000000"},
+			"nonce":     {"abc"},
+			"signature": {Signature(token, "1400000000", "abc")},
+		}
+		body := strings.Replace(testText(content, id), "gh_1", to, 1)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("POST", target+"?"+q.Encode(), strings.NewReader(body)))
+		return rec
+	}
+	for i, c := range []struct {
+		target, token, to, content, want string
+		code                             int
+	}{
+		{"/wechat/a", "tokena", "gh_a", "hi", "shared:wxa", 200},
+		{"/wechat/b", "tokenb", "gh_b", "hi", "shared:wxb", 200},
+		{"/wechat", "tokena", "gh_a", "b", "shared:wxa", 200},
+		{"/wechat", "tokenb", "gh_b", "b", "only b", 200},
+		{"/wechat/a", "tokenb", "gh_a", "hi", "", 401},
+		{"/wechat", "tokena", "gh_c", "hi", "", 404},
+	} {
+		rec := serve(c.target, c.token, c.to, c.content, strconv.Itoa(i))
+		if rec.Code != c.code || !strings.Contains(rec.Body.String(), c.want) {
+			t.Error(c, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestMuxAddWhileServing(t *testing.T) {
+	wc, _ := NewWeChatInMem("wxa", "secret", "token")
+	m := NewMux()
+	m.Add("a", "gh_a", wc)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			m.RegisterHandler(func(w RespondWriter, r *Request) error { return nil }, MsgTypeText)
+			m.Use(func(next HandleFunc) HandleFunc { return next })
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		testServe(wc, testText("hi", strconv.Itoa(i+1)))
+	}
+	<-done
+}
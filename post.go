@@ -1,7 +1,7 @@
 package wechat
 
 import (
-	"fmt"
+	"context"
 )
 
 //Interface to post message to WeChat server
@@ -16,63 +16,62 @@ type PostMessage interface {
 }
 
 func (w *WeChat) PostText(touser, content string) error {
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"text","text":{"content":"%v"}}`,
-			touser,
-			content)),
-		nil)
+	return w.PostTextContext(context.Background(), touser, content)
+}
+
+func (w *WeChat) PostTextContext(ctx context.Context, touser, content string) error {
+	return w.postMessage(ctx, touser, "text", map[string]string{"content": content})
 }
 
 func (w *WeChat) PostImage(touser, media_id string) error {
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"image","image":{"media_id":"%v"}}`,
-			touser,
-			media_id)),
-		nil)
+	return w.PostImageContext(context.Background(), touser, media_id)
+}
+
+func (w *WeChat) PostImageContext(ctx context.Context, touser, media_id string) error {
+	return w.postMessage(ctx, touser, "image", map[string]string{"media_id": media_id})
 }
 
 func (w *WeChat) PostVoice(touser, media_id string) error {
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"voice","voice":{"media_id":"%v"}}`,
-			touser,
-			media_id)),
-		nil)
+	return w.PostVoiceContext(context.Background(), touser, media_id)
+}
+
+func (w *WeChat) PostVoiceContext(ctx context.Context, touser, media_id string) error {
+	return w.postMessage(ctx, touser, "voice", map[string]string{"media_id": media_id})
 }
 
 func (w *WeChat) PostVideo(touser, media_id, title, description string) error {
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"video","video":{"media_id":"%v","title":"%v","description":"%v"}}`,
-			touser,
-			media_id,
-			title,
-			description)),
-		nil)
+	return w.PostVideoContext(context.Background(), touser, media_id, title, description)
+}
+
+func (w *WeChat) PostVideoContext(ctx context.Context, touser, media_id, title, description string) error {
+	return w.postMessage(ctx, touser, "video", map[string]string{
+		"media_id":    media_id,
+		"title":       title,
+		"description": description,
+	})
 }
 
 func (w *WeChat) PostMusic(touser string, music Music) error {
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"music","music":{"title":"%v","description":"%v","musicurl":"%v","hqmusicurl":"%v","thumb_media_id":"%v"}}`,
-			touser,
-			music.Title,
-			music.Description,
-			music.MusicUrl,
-			music.HQMusicUrl,
-			music.ThumbMediaId)),
-		nil)
+	return w.PostMusicContext(context.Background(), touser, music)
+}
+
+func (w *WeChat) PostMusicContext(ctx context.Context, touser string, music Music) error {
+	return w.postMessage(ctx, touser, "music", music)
 }
 
 func (w *WeChat) PostNews(touser string, articles []Article) error {
-	sas := ""
-	for _, a := range articles {
-		str := fmt.Sprintf(`{"title":"%v","description":"%v","url":"%v","picurl":"%v"}`, a.Title, a.Description, a.Url, a.PicUrl)
-		if sas == "" {
-			sas = str
-		} else {
-			sas += "," + str
-		}
-	}
-	return w.post(WeChatPost,
-		[]byte(fmt.Sprintf(`{"touser":"%v","msgtype":"news","news":{"articles":[%v]}}`,
-			touser, sas)), nil)
+	return w.PostNewsContext(context.Background(), touser, articles)
+}
+
+func (w *WeChat) PostNewsContext(ctx context.Context, touser string, articles []Article) error {
+	return w.postMessage(ctx, touser, "news", map[string][]Article{"articles": articles})
+}
 
+//Post customer-service message of msgType, content is marshaled as json.
+func (w *WeChat) postMessage(ctx context.Context, touser, msgType string, content interface{}) error {
+	return w.postJSON(ctx, WeChatPost, map[string]interface{}{
+		"touser":  touser,
+		"msgtype": msgType,
+		msgType:   content,
+	}, nil)
 }
@@ -0,0 +1,119 @@
+package wechat
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Default window of callback timestamp
+const DefaultReplayWindow = 5 * time.Minute
+
+//Storage of used nonces, used to reject replayed callbacks.
+//If the Storage passed to New does not implement it, nonces are kept in memory.
+type NonceStorage interface {
+	UseNonce(nonce string, expire time.Time) (fresh bool, err error) // Mark nonce as used until expire, fresh is false if it was used before
+}
+
+//Reject callbacks whose timestamp is out of window, or whose nonce was used
+//in window by another message. Rejected callbacks get 401.
+func WithReplayWindow(window time.Duration) Option {
+	return func(w *WeChat) error {
+		if window <= 0 {
+			window = DefaultReplayWindow
+		}
+		w.replayWindow = window
+		if _, ok := w.atrw.(NonceStorage); !ok {
+			w.nonces = &nonceCache{}
+		}
+		return nil
+	}
+}
+
+//Check the timestamp of callback.
+func (wc *WeChat) checkTimestamp(r *http.Request) bool {
+	if wc.replayWindow <= 0 {
+		return true
+	}
+	ts, err := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	d := time.Since(time.Unix(ts, 0))
+	return d <= wc.replayWindow && d >= -wc.replayWindow
+}
+
+//Check the nonce of callback. WeChat retries a message with the same
+//timestamp and nonce, so a reused nonce is accepted for the same message
+//if the storage deduplicates messages, and the retry gets the cached reply.
+//retry is true if the nonce was used by the message before, then the
+//message must still be remembered by the storage.
+func (wc *WeChat) checkNonce(r *http.Request, msg *Request) (ok, retry bool) {
+	if wc.replayWindow <= 0 {
+		return true, false
+	}
+	ts, _ := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+	expire := time.Unix(ts, 0).Add(wc.replayWindow)
+	nonce := r.FormValue("timestamp") + "#" + r.FormValue("nonce")
+	fresh, err := wc.useNonce(nonce, expire)
+	if err != nil {
+		wc.logger.Println(err)
+		return false, false
+	}
+	// Record the message using the nonce, so its retries are recognised
+	other, err := wc.useNonce(nonce+"#"+msg.dedupKey(), expire)
+	if err != nil {
+		wc.logger.Println(err)
+		return false, false
+	}
+	if fresh {
+		return true, false
+	}
+	_, dedup := wc.atrw.(DedupStorage)
+	return dedup && !other, true
+}
+
+func (wc *WeChat) useNonce(nonce string, expire time.Time) (bool, error) {
+	if ns, ok := wc.atrw.(NonceStorage); ok {
+		return ns.UseNonce(nonce, expire)
+	}
+	return wc.nonces.use(nonce, expire), nil
+}
+
+//Compare signatures in constant time.
+func equalSignature(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+type nonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	expiry expiryHeap // Nonces in order of expiry
+}
+
+func (c *nonceCache) use(nonce string, expire time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.nonces == nil {
+		c.nonces = map[string]time.Time{}
+	}
+	for {
+		k, ok := c.expiry.popExpired(now)
+		if !ok {
+			break
+		}
+		// The nonce may be used again after it expired
+		if e, ok := c.nonces[k]; ok && now.After(e) {
+			delete(c.nonces, k)
+		}
+	}
+	if e, ok := c.nonces[nonce]; ok && !now.After(e) {
+		return false
+	}
+	c.nonces[nonce] = expire
+	c.expiry.add(nonce, expire)
+	return true
+}
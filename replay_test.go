@@ -0,0 +1,82 @@
+package wechat
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	for i, storage := range []Storage{
+		&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}},
+		testPlainStorage{&MemStorage{appid: "wxappid", token: "token", at: &AccessToken{}}},
+	} {
+		wc, err := New(storage, WithReplayWindow(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc.RegisterHandler(func(w RespondWriter, r *Request) error {
+			w.ReplyText("echo:" + r.Content)
+			return nil
+		}, MsgTypeText)
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		first := testServeAt(wc, now, "n1", testText("hi", "1"))
+		if first.Code != 200 {
+			t.Error("fresh", first.Code)
+		}
+		// Retry of WeChat has the same timestamp and nonce
+		retry := testServeAt(wc, now, "n1", testText("hi", "1"))
+		if dedup := i == 0; dedup {
+			if retry.Code != 200 || retry.Body.String() != first.Body.String() {
+				t.Error("retried", retry.Code, retry.Body.String())
+			}
+		} else if retry.Code != 401 {
+			t.Error("retried without dedup", retry.Code)
+		}
+		if rec := testServeAt(wc, now, "n1", testText("bye", "9")); rec.Code != 401 {
+			t.Error("replayed", rec.Code)
+		}
+		if dedup := i == 0; dedup {
+			// Replayed in window after the message is forgotten
+			ms := storage.(*MemStorage)
+			ms.mu.Lock()
+			ms.messages["1"].Time = time.Now().Add(-DedupExpire - time.Second)
+			ms.mu.Unlock()
+			if rec := testServeAt(wc, now, "n1", testText("hi", "1")); rec.Code != 401 {
+				t.Error("replayed after dedup expired", rec.Code)
+			}
+		}
+		if rec := testServeAt(wc, now, "n2", testText("hi", "2")); rec.Code != 200 {
+			t.Error("new nonce", rec.Code)
+		}
+		stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+		if rec := testServeAt(wc, stale, "n3", testText("hi", "3")); rec.Code != 401 {
+			t.Error("stale", rec.Code)
+		}
+	}
+}
+
+//Storage only implements the Storage interface.
+type testPlainStorage struct {
+	Storage
+}
+
+func TestEqualSignature(t *testing.T) {
+	if !equalSignature("abc", "abc") || equalSignature("abc", "abd") || equalSignature("abc", "") {
+		t.Error("equalSignature")
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	c := &nonceCache{}
+	now := time.Now()
+	if !c.use("a", now.Add(-time.Second)) || !c.use("b", now.Add(time.Minute)) {
+		t.Fatal("fresh nonce rejected")
+	}
+	if !c.use("c", now.Add(time.Minute)) || c.use("b", now.Add(time.Minute)) {
+		t.Error("used nonce accepted")
+	}
+	if _, ok := c.nonces["a"]; ok || len(c.nonces) != 2 || len(c.expiry) != 2 {
+		t.Error(c.nonces, len(c.expiry))
+	}
+}
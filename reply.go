@@ -0,0 +1,148 @@
+package wechat
+
+import (
+	"encoding/xml"
+)
+
+//Reply type of transferring conversation to customer service
+const replyTransferCustomerService = "transfer_customer_service"
+
+//Passive reply message, every reply embeds replyHeader.
+type replyMessage interface {
+	header() *replyHeader
+}
+
+//Common fields of passive reply
+type replyHeader struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   cdata
+	FromUserName cdata
+	CreateTime   int64
+	MsgType      cdata
+}
+
+func (h *replyHeader) header() *replyHeader {
+	return h
+}
+
+type mediaReply struct {
+	MediaId cdata
+}
+
+type textReply struct {
+	replyHeader
+	Content cdata
+}
+
+type imageReply struct {
+	replyHeader
+	Image mediaReply
+}
+
+type voiceReply struct {
+	replyHeader
+	Voice mediaReply
+}
+
+type videoReply struct {
+	replyHeader
+	Video struct {
+		MediaId     cdata
+		Title       cdata
+		Description cdata
+	}
+}
+
+type musicReply struct {
+	replyHeader
+	Music struct {
+		Title        cdata
+		Description  cdata
+		MusicUrl     cdata
+		HQMusicUrl   cdata
+		ThumbMediaId cdata
+	}
+}
+
+type newsReply struct {
+	replyHeader
+	ArticleCount int
+	Articles     []articleReply `xml:"Articles>item"`
+}
+
+type articleReply struct {
+	Title       cdata
+	Description cdata
+	PicUrl      cdata
+	Url         cdata
+}
+
+type transferReply struct {
+	replyHeader
+	TransInfo *struct {
+		KfAccount cdata
+	} `xml:",omitempty"`
+}
+
+//Marshal reply message, encoding/xml splits "]]>" in CDATA sections,
+//so user content can not break the reply.
+func marshalReply(msg replyMessage, msgType, toUserName, fromUserName string, createTime int64) (string, error) {
+	h := msg.header()
+	h.ToUserName = cdata{toUserName}
+	h.FromUserName = cdata{fromUserName}
+	h.CreateTime = createTime
+	h.MsgType = cdata{msgType}
+	data, err := xml.Marshal(msg)
+	return string(data), err
+}
+
+func newTextReply(text string) *textReply {
+	return &textReply{Content: cdata{text}}
+}
+
+func newImageReply(mediaId string) *imageReply {
+	return &imageReply{Image: mediaReply{cdata{mediaId}}}
+}
+
+func newVoiceReply(mediaId string) *voiceReply {
+	return &voiceReply{Voice: mediaReply{cdata{mediaId}}}
+}
+
+func newVideoReply(mediaId, title, description string) *videoReply {
+	v := &videoReply{}
+	v.Video.MediaId = cdata{mediaId}
+	v.Video.Title = cdata{title}
+	v.Video.Description = cdata{description}
+	return v
+}
+
+func newMusicReply(music *Music) *musicReply {
+	m := &musicReply{}
+	m.Music.Title = cdata{music.Title}
+	m.Music.Description = cdata{music.Description}
+	m.Music.MusicUrl = cdata{music.MusicUrl}
+	m.Music.HQMusicUrl = cdata{music.HQMusicUrl}
+	m.Music.ThumbMediaId = cdata{music.ThumbMediaId}
+	return m
+}
+
+func newNewsReply(articles []Article) *newsReply {
+	n := &newsReply{ArticleCount: len(articles)}
+	for _, a := range articles {
+		n.Articles = append(n.Articles, articleReply{
+			Title:       cdata{a.Title},
+			Description: cdata{a.Description},
+			PicUrl:      cdata{a.PicUrl},
+			Url:         cdata{a.Url},
+		})
+	}
+	return n
+}
+
+func newTransferReply(kfAccount string) *transferReply {
+	t := &transferReply{}
+	if kfAccount != "" {
+		t.TransInfo = &struct{ KfAccount cdata }{cdata{kfAccount}}
+	}
+	return t
+}
@@ -0,0 +1,70 @@
+package wechat
+
+import (
+	"encoding/xml"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestReplyGolden(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		msgType string
+		msg     replyMessage
+	}{
+		{"text", "text", newTextReply("你好 <b>&amp;</b>")},
+		{"text_cdata", "text", newTextReply("a]]>b")},
+		{"image", "image", newImageReply("media_image")},
+		{"voice", "voice", newVoiceReply("media_voice")},
+		{"video", "video", newVideoReply("media_video", "title", "description")},
+		{"music", "music", newMusicReply(&Music{
+			Title:        "title",
+			Description:  "description",
+			MusicUrl:     "http://example.com/a.mp3",
+			HQMusicUrl:   "http://example.com/a_hq.mp3",
+			ThumbMediaId: "media_thumb",
+		})},
+		{"news", "news", newNewsReply([]Article{
+			{Title: "t1", Description: "d1", PicUrl: "http://example.com/1.jpg", Url: "http://example.com/1"},
+			{Title: "t2", Description: "d2", PicUrl: "http://example.com/2.jpg", Url: "http://example.com/2?a=1&b=2"},
+		})},
+		{"transfer", "transfer_customer_service", newTransferReply("")},
+		{"transfer_account", "transfer_customer_service", newTransferReply("test1@test")},
+	} {
+		got, err := marshalReply(c.msg, c.msgType, "user", "gh_1", 1400000000)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		golden := filepath.Join("testdata", "reply_"+c.name+".xml")
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s:\ngot  %s\nwant %s", c.name, got, want)
+		}
+	}
+}
+
+func TestReplyCDATA(t *testing.T) {
+	data, err := marshalReply(newTextReply("a]]><x/>b"), "text", "user", "gh_1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Request
+	if err := xml.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Content != "a]]><x/>b" || r.MsgType != "text" || r.ToUserName != "user" {
+		t.Error(r)
+	}
+}
@@ -0,0 +1,137 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+//Error codes of WeChat server
+const (
+	ErrCodeSystemBusy         = -1    // System is busy, try later
+	ErrCodeInvalidCredential  = 40001 // Access token is invalid or not latest
+	ErrCodeInvalidAccessToken = 40014 // Access token is invalid
+	ErrCodeAccessTokenExpired = 42001 // Access token is expired
+)
+
+//HTTP status of WeChat server is not 200.
+type ErrHTTPStatus struct {
+	StatusCode int
+}
+
+func (e *ErrHTTPStatus) Error() string {
+	return "wechat: http status " + strconv.Itoa(e.StatusCode)
+}
+
+//Retry policy of API calls. The delay before the nth retry is
+//BaseDelay * 2^(n-1), at most MaxDelay, randomized by Jitter.
+//
+//Calls rejected for invalid access token (40001, 40014, 42001) are retried
+//at once with a new token. System busy (-1), network errors and 5xx are
+//retried only if the call is idempotent, so messages are never sent twice.
+type RetryPolicy struct {
+	MaxAttempts int           // Max attempts of one call, 1 means no retry
+	BaseDelay   time.Duration // Delay before the first retry
+	MaxDelay    time.Duration // Max delay between retries
+	Jitter      float64       // Delay is randomized by ±Jitter, from 0 to 1
+}
+
+//Default retry policy of WeChat
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Jitter:      0.2,
+}
+
+//Use retry policy for API calls.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(w *WeChat) error {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		w.retry = policy
+		return nil
+	}
+}
+
+//Check whether the error of call should be retried, and whether access
+//token should be refreshed before retry.
+func (p RetryPolicy) classify(err error, needAccessToken, idempotent bool) (retry, refresh bool) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, false
+	}
+	var ewc *ErrWeChat
+	if errors.As(err, &ewc) {
+		switch ewc.ErrCode {
+		case ErrCodeInvalidCredential, ErrCodeInvalidAccessToken, ErrCodeAccessTokenExpired:
+			return needAccessToken, needAccessToken
+		case ErrCodeSystemBusy:
+			return idempotent, false
+		}
+		return false, false
+	}
+	var status *ErrHTTPStatus
+	if errors.As(err, &status) {
+		return idempotent && status.StatusCode >= 500, false
+	}
+	// The request was not sent if it failed to dial
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true, false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return idempotent, false
+	}
+	return false, false
+}
+
+//Delay before the retry after attempt, without jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+//Delay before the retry after attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff(attempt)
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+//Max duration of a call with all retries, each attempt takes at most timeout.
+func (p RetryPolicy) budget(timeout time.Duration) time.Duration {
+	d := time.Duration(p.MaxAttempts) * timeout
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		d += time.Duration((1 + p.Jitter) * float64(p.backoff(attempt)))
+	}
+	return d
+}
+
+//Wait before the retry after attempt, return error if ctx is done.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	d := p.delay(attempt)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryClassify(t *testing.T) {
+	p := DefaultRetryPolicy
+	dial := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	read := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	for _, c := range []struct {
+		err            error
+		idempotent     bool
+		retry, refresh bool
+	}{
+		{nil, true, false, false},
+		{&ErrWeChat{ErrCode: ErrCodeAccessTokenExpired}, false, true, true},
+		{&ErrWeChat{ErrCode: ErrCodeInvalidCredential}, false, true, true},
+		{&ErrWeChat{ErrCode: ErrCodeInvalidAccessToken}, true, true, true},
+		{&ErrWeChat{ErrCode: ErrCodeSystemBusy}, true, true, false},
+		{&ErrWeChat{ErrCode: ErrCodeSystemBusy}, false, false, false},
+		{&ErrWeChat{ErrCode: 40003}, true, false, false},
+		{&ErrHTTPStatus{StatusCode: 502}, true, true, false},
+		{&ErrHTTPStatus{StatusCode: 502}, false, false, false},
+		{&ErrHTTPStatus{StatusCode: 404}, true, false, false},
+		{dial, false, true, false},
+		{read, true, true, false},
+		{read, false, false, false},
+		{context.DeadlineExceeded, true, false, false},
+	} {
+		retry, refresh := p.classify(c.err, true, c.idempotent)
+		if retry != c.retry || refresh != c.refresh {
+			t.Error(c.err, c.idempotent, retry, refresh)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, want := range []time.Duration{0, 100, 200, 400, 800, 1000, 1000} {
+		if attempt == 0 {
+			continue
+		}
+		if d := p.delay(attempt); d != want*time.Millisecond {
+			t.Error(attempt, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Error(d)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	// 3 attempts of 30s, and retries after 100ms and 200ms with 20% jitter
+	if d := DefaultRetryPolicy.budget(30 * time.Second); d != 90*time.Second+360*time.Millisecond {
+		t.Error(d)
+	}
+	if d := (RetryPolicy{MaxAttempts: 1}).budget(time.Second); d != time.Second {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,88 @@
+package wechat
+
+import (
+	"regexp"
+	"strings"
+)
+
+//Priority of routes registered by RegisterKeyword, RegisterContent,
+//RegisterPrefix and RegisterEventKey, they are matched before plain routes.
+const PriorityMatch = 10
+
+//Predicate on request
+type MatchFunc func(*Request) bool
+
+//Match text message whose content equals one of keywords.
+func MatchKeyword(keywords ...string) MatchFunc {
+	return func(r *Request) bool {
+		for _, k := range keywords {
+			if r.Content == k {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//Match text message whose content has one of prefixes.
+func MatchPrefix(prefixes ...string) MatchFunc {
+	return func(r *Request) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(r.Content, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//Match text message whose content matches the regexp.
+func MatchContent(pattern string) MatchFunc {
+	reg := regexp.MustCompile(pattern)
+	return func(r *Request) bool {
+		return reg.MatchString(r.Content)
+	}
+}
+
+//Match event whose EventKey equals one of keys.
+func MatchEventKey(keys ...string) MatchFunc {
+	return func(r *Request) bool {
+		for _, k := range keys {
+			if r.EventKey == k {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//Register handler for text messages equal to one of keywords.
+func (w *WeChat) RegisterKeyword(handler HandleFunc, keywords ...string) {
+	w.RegisterMatch(MsgTypeText, MatchKeyword(keywords...), handler)
+}
+
+//Register handler for text messages with one of prefixes.
+func (w *WeChat) RegisterPrefix(handler HandleFunc, prefixes ...string) {
+	w.RegisterMatch(MsgTypeText, MatchPrefix(prefixes...), handler)
+}
+
+//Register handler for text messages matching the regexp.
+func (w *WeChat) RegisterContent(handler HandleFunc, pattern string) {
+	w.RegisterMatch(MsgTypeText, MatchContent(pattern), handler)
+}
+
+//Register handler for events with one of keys, such as menu click.
+func (w *WeChat) RegisterEventKey(handler HandleFunc, keys ...string) {
+	w.RegisterMatch(MsgTypeEvent, MatchEventKey(keys...), handler)
+}
+
+//Register handler for requests matching pattern and predicate.
+func (w *WeChat) RegisterMatch(pattern string, match MatchFunc, handler HandleFunc, middlewares ...Middleware) {
+	w.AddRoute(&Route{
+		Regex:       regexp.MustCompile(pattern),
+		Match:       match,
+		Priority:    PriorityMatch,
+		Handle:      handler,
+		Middlewares: middlewares,
+	})
+}
@@ -0,0 +1,50 @@
+package wechat
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	wc, err := NewWeChatInMem("wxappid", "secret", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := func(text string) HandleFunc {
+		return func(w RespondWriter, r *Request) error {
+			w.ReplyText(text)
+			return nil
+		}
+	}
+	wc.RegisterHandler(reply("text"), MsgTypeText)
+	wc.RegisterKeyword(reply("help"), "help", "帮助")
+	wc.RegisterPrefix(reply("order"), "order ")
+	wc.RegisterContent(reply("number"), `^\d+$`)
+	wc.RegisterEventKey(reply("click"), "V1001")
+	wc.AddRoute(&Route{
+		Regex:    regexp.MustCompile(MsgTypeText),
+		Match:    func(r *Request) bool { return r.Content == "vip" },
+		Priority: 100,
+		Handle:   reply("vip"),
+	})
+	wc.SetDefaultHandler(reply("default"))
+
+	event := func(key, createTime string) string {
+		return `<xml><ToUserName>gh_1</ToUserName><FromUserName>user</FromUserName><CreateTime>` + createTime + `</CreateTime><MsgType>event</MsgType><Event>CLICK</Event><EventKey>` + key + `</EventKey></xml>`
+	}
+	for body, want := range map[string]string{
+		testText("help", "1"):     "help",
+		testText("帮助", "2"):       "help",
+		testText("order 12", "3"): "order",
+		testText("42", "4"):       "number",
+		testText("vip", "5"):      "vip",
+		testText("hello", "6"):    "text",
+		event("V1001", "1"):       "click",
+		event("V1002", "2"):       "default",
+	} {
+		if got := testServe(wc, body).Body.String(); !strings.Contains(got, "[CDATA["+want+"]]") {
+			t.Error(body, got)
+		}
+	}
+}
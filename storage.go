@@ -1,8 +1,11 @@
 package wechat
 
 import (
+	"container/heap"
 	"errors"
 	"log"
+	"sync"
+	"time"
 )
 
 //Store some important data get from wechat server
@@ -17,13 +20,18 @@ type Storage interface {
 }
 
 //Create WeChat using in memory storage.
-func NewWeChatInMem(appid, secret, token string) (*WeChat, error) {
-	return New(&MemStorage{
+func NewWeChatInMem(appid, secret, token string, options ...Option) (*WeChat, error) {
+	return New(NewMemStorage(appid, secret, token), options...)
+}
+
+//Create in memory storage, it can be shared by several WeChat in process.
+func NewMemStorage(appid, secret, token string) *MemStorage {
+	return &MemStorage{
 		appid:  appid,
 		secret: secret,
 		token:  token,
 		at:     &AccessToken{},
-	})
+	}
 }
 
 //In memory storage struct
@@ -34,16 +42,27 @@ type MemStorage struct {
 	token  string
 	at     *AccessToken
 	idname map[string]*user
+
+	mu       sync.Mutex
+	messages map[string]*message
+	expiry   expiryHeap // Keys of messages in order of expiry
+	nonces   nonceCache
+	leases   map[string]lease
+	users    map[string]*follower
 }
 
 func (s *MemStorage) ReadAccessToken() (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.at == nil {
-		return *s.at, errors.New("No access token was found!")
+		return AccessToken{}, errors.New("No access token was found!")
 	} else {
 		return *s.at, nil
 	}
 }
 func (s *MemStorage) WriteAccessToken(at AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.at = &at
 	return nil
 }
@@ -78,3 +97,162 @@ func (s *MemStorage) SetUserName(id, name, admin string) {
 		Admin: admin,
 	}
 }
+
+//Received message, used to deduplicate retries.
+type message struct {
+	Key   string `bson:"_id"`
+	Reply string
+	Done  bool
+	Time  time.Time
+}
+
+//Key which expires at expire
+type expiryEntry struct {
+	key    string
+	expire time.Time
+}
+
+//Min heap of keys by expiry, so expired keys are evicted without scanning all.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expire.Before(h[j].expire) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+//Add key which expires at expire.
+func (h *expiryHeap) add(key string, expire time.Time) {
+	heap.Push(h, expiryEntry{key, expire})
+}
+
+//Remove and return the first key expired before now.
+func (h *expiryHeap) popExpired(now time.Time) (string, bool) {
+	if h.Len() == 0 || !now.After((*h)[0].expire) {
+		return "", false
+	}
+	return heap.Pop(h).(expiryEntry).key, true
+}
+
+func (s *MemStorage) MarkMessage(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages == nil {
+		s.messages = map[string]*message{}
+	}
+	now := time.Now()
+	for {
+		k, ok := s.expiry.popExpired(now)
+		if !ok {
+			break
+		}
+		// The key may be marked again after it expired
+		if m, ok := s.messages[k]; ok && now.Sub(m.Time) > DedupExpire {
+			delete(s.messages, k)
+		}
+	}
+	if m, ok := s.messages[key]; ok && now.Sub(m.Time) <= DedupExpire {
+		return false, nil
+	}
+	s.messages[key] = &message{Key: key, Time: now}
+	s.expiry.add(key, now.Add(DedupExpire))
+	return true, nil
+}
+
+func (s *MemStorage) SaveMessageReply(key, reply string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.messages[key]; ok {
+		m.Reply = reply
+		m.Done = true
+	}
+	return nil
+}
+
+func (s *MemStorage) ReadMessageReply(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[key]
+	if !ok {
+		return "", false, errors.New("Message not found")
+	}
+	return m.Reply, m.Done, nil
+}
+
+func (s *MemStorage) UseNonce(nonce string, expire time.Time) (bool, error) {
+	return s.nonces.use(nonce, expire), nil
+}
+
+func (s *MemStorage) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[name]; ok && l.Owner != owner && time.Now().Before(l.Expire) {
+		return false, nil
+	}
+	if s.leases == nil {
+		s.leases = map[string]lease{}
+	}
+	s.leases[name] = lease{Name: name, Owner: owner, Expire: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *MemStorage) ReleaseLock(name, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[name]; ok && l.Owner == owner {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+func (s *MemStorage) SaveUser(u *User, synced time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = map[string]*follower{}
+	}
+	if f, ok := s.users[u.Openid]; ok && f.Removed.After(synced) {
+		return nil
+	}
+	c := *u
+	s.users[u.Openid] = &follower{Openid: u.Openid, User: &c, Synced: synced}
+	return nil
+}
+
+func (s *MemStorage) RemoveUser(openid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = map[string]*follower{}
+	}
+	now := time.Now()
+	s.users[openid] = &follower{Openid: openid, Synced: now, Removed: now}
+	return nil
+}
+
+func (s *MemStorage) RemoveUsersBefore(synced time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, f := range s.users {
+		if f.Synced.Before(synced) {
+			delete(s.users, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemStorage) ReadUser(openid string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.users[openid]
+	if !ok || f.User == nil {
+		return nil, ErrUserNotFound
+	}
+	c := *f.User
+	return &c, nil
+}
@@ -0,0 +1,148 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+)
+
+//Industry of account, used by template messages
+type Industry struct {
+	FirstClass  string `json:"first_class"`
+	SecondClass string `json:"second_class"`
+}
+
+//Template added to account
+type Template struct {
+	TemplateId      string `json:"template_id"`
+	Title           string `json:"title"`
+	PrimaryIndustry string `json:"primary_industry"`
+	DeputyIndustry  string `json:"deputy_industry"`
+	Content         string `json:"content"`
+	Example         string `json:"example"`
+}
+
+//Value of template data field, Color is like "#173177".
+type TemplateValue struct {
+	Value string `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+//Data of template message, keyed by field name such as "first" and "remark".
+type TemplateData map[string]TemplateValue
+
+//Miniprogram opened by template message
+type TemplateMiniProgram struct {
+	AppId    string `json:"appid"`
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+//Template message, it can be sent out of the 48 hours window.
+type TemplateMessage struct {
+	ToUser      string               `json:"touser"`
+	TemplateId  string               `json:"template_id"`
+	Url         string               `json:"url,omitempty"`
+	MiniProgram *TemplateMiniProgram `json:"miniprogram,omitempty"` // Opened instead of Url if it is supported
+	Data        TemplateData         `json:"data"`
+}
+
+//Set industries of account, ids are defined by WeChat.
+func (w *WeChat) SetIndustry(primary, secondary string) error {
+	return w.SetIndustryContext(context.Background(), primary, secondary)
+}
+
+//Set industries of account with context
+func (w *WeChat) SetIndustryContext(ctx context.Context, primary, secondary string) error {
+	data, err := json.Marshal(map[string]string{"industry_id1": primary, "industry_id2": secondary})
+	if err != nil {
+		return err
+	}
+	return w.postIdempotent(ctx, WeChatTemplateSetIndustry, data, nil)
+}
+
+//Get industries of account.
+func (w *WeChat) GetIndustry() (primary, secondary Industry, err error) {
+	return w.GetIndustryContext(context.Background())
+}
+
+//Get industries of account with context
+func (w *WeChat) GetIndustryContext(ctx context.Context) (primary, secondary Industry, err error) {
+	var res struct {
+		Primary   Industry `json:"primary_industry"`
+		Secondary Industry `json:"secondary_industry"`
+	}
+	err = w.get(ctx, WeChatTemplateGetIndustry, &res, true)
+	return res.Primary, res.Secondary, err
+}
+
+//Add template from library by its short id, return template id.
+func (w *WeChat) AddTemplate(shortId string) (string, error) {
+	return w.AddTemplateContext(context.Background(), shortId)
+}
+
+//Add template with context
+func (w *WeChat) AddTemplateContext(ctx context.Context, shortId string) (string, error) {
+	data, err := json.Marshal(map[string]string{"template_id_short": shortId})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		TemplateId string `json:"template_id"`
+	}
+	err = w.post(ctx, WeChatTemplateAdd, data, &res)
+	return res.TemplateId, err
+}
+
+//Get templates added to account.
+func (w *WeChat) GetTemplates() ([]Template, error) {
+	return w.GetTemplatesContext(context.Background())
+}
+
+//Get templates with context
+func (w *WeChat) GetTemplatesContext(ctx context.Context) ([]Template, error) {
+	var res struct {
+		List []Template `json:"template_list"`
+	}
+	err := w.get(ctx, WeChatTemplateList, &res, true)
+	return res.List, err
+}
+
+//Delete template from account.
+func (w *WeChat) DeleteTemplate(templateId string) error {
+	return w.DeleteTemplateContext(context.Background(), templateId)
+}
+
+//Delete template with context
+func (w *WeChat) DeleteTemplateContext(ctx context.Context, templateId string) error {
+	data, err := json.Marshal(map[string]string{"template_id": templateId})
+	if err != nil {
+		return err
+	}
+	return w.postIdempotent(ctx, WeChatTemplateDelete, data, nil)
+}
+
+//Send template message, return msgid which is in TEMPLATESENDJOBFINISH event.
+func (w *WeChat) SendTemplate(msg *TemplateMessage) (int64, error) {
+	return w.SendTemplateContext(context.Background(), msg)
+}
+
+//Send template message with context
+func (w *WeChat) SendTemplateContext(ctx context.Context, msg *TemplateMessage) (int64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	var res struct {
+		MsgId int64 `json:"msgid"`
+	}
+	err = w.post(ctx, WeChatTemplateSend, data, &res)
+	return res.MsgId, err
+}
+
+//Call callback with result of template message, which is pushed by
+//TEMPLATESENDJOBFINISH event to ServeHTTP. The event is also routed.
+//The callback runs in its own goroutine, a panic in it is recovered and logged.
+func (w *WeChat) OnTemplateSendJobFinish(callback func(job *TemplateSendJobFinish)) {
+	w.routeMu.Lock()
+	defer w.routeMu.Unlock()
+	w.onTemplateSend = callback
+}
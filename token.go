@@ -0,0 +1,144 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Renew access token in background this long before it expires
+	DefaultTokenRenewal = 10 * time.Minute
+	// Access token is treated as expired this long before ExpireTime
+	tokenSafety = time.Minute
+	// Lifetime of access token issued by WeChat server
+	tokenLifetime = 7200 * time.Second
+)
+
+var ErrTokenRenewal = errors.New("wechat: token renewal must be shorter than lifetime of access token")
+
+//Renew access token in background when it expires within renewal.
+//Zero renewal disables background renewal. Renewal must leave a fresh
+//token unrenewed, otherwise every call would refresh it.
+func WithTokenRenewal(renewal time.Duration) Option {
+	return func(w *WeChat) error {
+		if renewal < 0 || renewal+tokenSafety >= tokenLifetime {
+			return ErrTokenRenewal
+		}
+		w.tokenRenewal = renewal
+		return nil
+	}
+}
+
+//Access token refresh in flight, shared by concurrent callers.
+type tokenCall struct {
+	done chan struct{}
+	at   AccessToken
+	err  error
+}
+
+//Get Access Token
+//Expired token is refreshed once for all concurrent callers, token that
+//expires soon is returned and renewed in background.
+func (w *WeChat) getAccessToken(ctx context.Context) (AccessToken, error) {
+	at, err := w.atrw.ReadAccessToken()
+	if err == nil && at.Token != "" {
+		left := time.Until(at.ExpireTime)
+		if left > tokenSafety {
+			// Token from token source is renewed by the token server
+			if left < w.tokenRenewal+tokenSafety && w.source == nil {
+				w.refreshAccessToken(at)
+			}
+			return at, nil
+		}
+	}
+	c := w.refreshAccessToken(at)
+	select {
+	case <-c.done:
+		return c.at, c.err
+	case <-ctx.Done():
+		return AccessToken{}, ctx.Err()
+	}
+}
+
+//Start refreshing access token, or join the refresh in flight.
+//stale is the token read from storage by the caller.
+func (w *WeChat) refreshAccessToken(stale AccessToken) *tokenCall {
+	w.tokenMu.Lock()
+	defer w.tokenMu.Unlock()
+	if w.tokenCall != nil {
+		return w.tokenCall
+	}
+	c := &tokenCall{done: make(chan struct{})}
+	w.tokenCall = c
+	go func() {
+		// The refresh is shared, so it does not use the context of any caller
+		if ls, ok := w.atrw.(LockStorage); ok {
+			c.at, c.err = w.fetchAccessTokenLocked(ls, stale)
+		} else if at, ok := w.refreshedAccessToken(stale); ok {
+			// Refreshed after the caller read stale
+			c.at = at
+		} else {
+			c.at, c.err = w.fetchAccessToken(context.Background(), stale)
+		}
+		w.tokenMu.Lock()
+		w.tokenCall = nil
+		w.tokenMu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+//Read access token from storage, ok if it was refreshed since stale was read.
+func (w *WeChat) refreshedAccessToken(stale AccessToken) (AccessToken, bool) {
+	at, err := w.atrw.ReadAccessToken()
+	if err == nil && at.Token != "" && at.Token != stale.Token && time.Until(at.ExpireTime) > tokenSafety {
+		return at, true
+	}
+	return AccessToken{}, false
+}
+
+//Response of access token
+type tokenResponse struct {
+	Token  string `json:"access_token"` // Access Token
+	Expire int64  `json:"expires_in"`   // Seconds before access token expires
+}
+
+func (t *tokenResponse) accessToken() AccessToken {
+	return AccessToken{
+		Token:      t.Token,
+		ExpireTime: time.Now().Add(time.Duration(t.Expire) * time.Second),
+	}
+}
+
+//Fetch access token from WeChat server or token source, and write it to storage.
+func (w *WeChat) fetchAccessToken(ctx context.Context, stale AccessToken) (AccessToken, error) {
+	var at AccessToken
+	if w.source != nil {
+		var err error
+		if at, err = w.source.AccessToken(ctx, stale.Token); err != nil {
+			return AccessToken{}, err
+		}
+	} else {
+		xxx := &tokenResponse{}
+		if err := w.get(ctx, fmt.Sprintf(WeChatToken, w.appid, w.secret), xxx, false); err != nil {
+			return AccessToken{}, err
+		}
+		at = xxx.accessToken()
+	}
+	if err := w.atrw.WriteAccessToken(at); err != nil {
+		w.logger.Println(err)
+	}
+	return at, nil
+}
+
+//Invalidate access token rejected by WeChat server, the next call fetches a new one.
+//The token is kept as expired, so the refresh knows which token is stale.
+func (w *WeChat) invalidateAccessToken(token string) {
+	if at, err := w.atrw.ReadAccessToken(); err == nil && at.Token == token {
+		if err := w.atrw.WriteAccessToken(AccessToken{Token: token}); err != nil {
+			w.logger.Println(err)
+		}
+	}
+}
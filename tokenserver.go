@@ -0,0 +1,114 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//Source of access token used instead of appid and secret.
+type TokenSource interface {
+	//Get valid access token. stale is the token expired or rejected by WeChat
+	//server, it must not be returned again; it is empty on the first call.
+	AccessToken(ctx context.Context, stale string) (AccessToken, error)
+}
+
+//Get access token from source, such as the token server of another process.
+//The secret of account is not needed.
+func WithTokenSource(source TokenSource) Option {
+	return func(w *WeChat) error {
+		w.source = source
+		return nil
+	}
+}
+
+//Get access token from token server at url, which is served by TokenHandler
+//with the same key.
+func WithTokenServer(url, key string) Option {
+	return func(w *WeChat) error {
+		w.source = &tokenServer{url: url, key: key, wechat: w}
+		return nil
+	}
+}
+
+//Handler serving access token to trusted clients, which send key as
+//"Authorization: Bearer <key>". Clients using WithTokenServer pass the token
+//rejected by WeChat server as "stale", which is invalidated and refreshed.
+//The response has the same format as the token API of WeChat server.
+func (w *WeChat) TokenHandler(key string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if key == "" || !equalSignature("Bearer "+key, r.Header.Get("Authorization")) {
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if stale := r.FormValue("stale"); stale != "" {
+			w.invalidateAccessToken(stale)
+		}
+		at, err := w.getAccessToken(r.Context())
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err != nil {
+			w.logger.Println(err)
+			if ewc, ok := err.(*ErrWeChat); ok {
+				json.NewEncoder(rw).Encode(ewc)
+			} else {
+				http.Error(rw, err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+		json.NewEncoder(rw).Encode(&tokenResponse{
+			Token:  at.Token,
+			Expire: int64(time.Until(at.ExpireTime) / time.Second),
+		})
+	})
+}
+
+//Token source of token server
+type tokenServer struct {
+	url    string
+	key    string
+	wechat *WeChat
+}
+
+func (s *tokenServer) AccessToken(ctx context.Context, stale string) (AccessToken, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultAPITimeout)
+		defer cancel()
+	}
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if stale != "" {
+		q := u.Query()
+		q.Set("stale", stale)
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.key)
+	resp, err := s.wechat.client.Do(req)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return AccessToken{}, &ErrHTTPStatus{StatusCode: resp.StatusCode}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	res := &tokenResponse{}
+	if err := decodeResponse(body, res); err != nil {
+		return AccessToken{}, err
+	}
+	return res.accessToken(), nil
+}
@@ -1,43 +1,183 @@
 package wechat
 
 import (
+	"context"
 	"fmt"
 )
 
 type User struct {
-	Subscribe     int
-	Openid        string `json:",omitempty"`
-	Nickname      string `json:",omitempty"`
-	Sex           int    `json:",omitempty"`
-	City          string `json:",omitempty"`
-	Country       string `json:",omitempty"`
-	Province      string `json:",omitempty"`
-	Language      string `json:",omitempty"`
-	Headimgurl    string `json:",omitempty"`
-	SubscribeTime int64  `json:"subscribe_time,omitempty"`
+	Subscribe      int
+	Openid         string `json:",omitempty"`
+	Nickname       string `json:",omitempty"`
+	Sex            int    `json:",omitempty"`
+	City           string `json:",omitempty"`
+	Country        string `json:",omitempty"`
+	Province       string `json:",omitempty"`
+	Language       string `json:",omitempty"`
+	Headimgurl     string `json:",omitempty"`
+	SubscribeTime  int64  `json:"subscribe_time,omitempty"`
+	Groupid        int    `json:",omitempty"`
+	TagidList      []int  `json:"tagid_list,omitempty"`
+	Remark         string `json:",omitempty"`
+	Unionid        string `json:",omitempty"`
+	SubscribeScene string `json:"subscribe_scene,omitempty"` // Source of subscription, such as ADD_SCENE_QR_CODE
+	QrScene        int    `json:"qr_scene,omitempty"`
+	QrSceneStr     string `json:"qr_scene_str,omitempty"`
+}
+
+//Tag of users
+type Tag struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count,omitempty"` // Count of users with tag
 }
 
 //Get user infomation from wechat
 func (w *WeChat) GetUser(openid, lang string) (*User, error) {
+	return w.GetUserContext(context.Background(), openid, lang)
+}
+
+//Get user infomation from wechat with context
+func (w *WeChat) GetUserContext(ctx context.Context, openid, lang string) (*User, error) {
 	u := &User{}
 	if lang == "" {
 		lang = LANG_CN
 	}
-	err := w.get(fmt.Sprintf(WeChatUserGet, openid, lang)+`%v`, u, true)
+	err := w.get(ctx, fmt.Sprintf(WeChatUserGet, openid, lang)+`%v`, u, true)
 	return u, err
 }
 
 //Get all user from wechat
 func (w *WeChat) GetAllUser(firstid string) ([]string, string, error) {
+	return w.GetAllUserContext(context.Background(), firstid)
+}
+
+//Get all user from wechat with context
+func (w *WeChat) GetAllUserContext(ctx context.Context, firstid string) ([]string, string, error) {
+	_, ids, next, err := w.getUserPage(ctx, firstid)
+	return ids, next, err
+}
+
+//Get page of followers, at most 10000 each time.
+func (w *WeChat) getUserPage(ctx context.Context, firstid string) (total int, ids []string, next string, err error) {
 	var a struct {
 		Total int
 		Count int
 		Data  map[string][]string
 		Next  string `json:"next_openid"`
 	}
-	if err := w.get(fmt.Sprintf(WeChatUserGetAll, firstid)+`%v`, &a, true); err != nil {
+	if err := w.get(ctx, fmt.Sprintf(WeChatUserGetAll, firstid)+`%v`, &a, true); err != nil {
+		return 0, nil, "", err
+	}
+	return a.Total, a.Data["openid"], a.Next, nil
+}
+
+//Create tag
+func (w *WeChat) CreateTag(name string) (Tag, error) {
+	return w.CreateTagContext(context.Background(), name)
+}
+
+//Create tag with context
+func (w *WeChat) CreateTagContext(ctx context.Context, name string) (Tag, error) {
+	t := map[string]Tag{}
+	err := w.postJSON(ctx, WeChatTagCreate, map[string]Tag{"tag": {Name: name}}, &t)
+	return t["tag"], err
+}
+
+//Get all tags
+func (w *WeChat) GetTags() ([]Tag, error) {
+	return w.GetTagsContext(context.Background())
+}
+
+//Get all tags with context
+func (w *WeChat) GetTagsContext(ctx context.Context) ([]Tag, error) {
+	t := map[string][]Tag{}
+	err := w.get(ctx, WeChatTagGet, &t, true)
+	return t["tags"], err
+}
+
+//Rename tag
+func (w *WeChat) UpdateTag(id int, name string) error {
+	return w.UpdateTagContext(context.Background(), id, name)
+}
+
+//Rename tag with context
+func (w *WeChat) UpdateTagContext(ctx context.Context, id int, name string) error {
+	return w.postJSONIdempotent(ctx, WeChatTagUpdate, map[string]Tag{"tag": {Id: id, Name: name}}, nil)
+}
+
+//Delete tag
+func (w *WeChat) DeleteTag(id int) error {
+	return w.DeleteTagContext(context.Background(), id)
+}
+
+//Delete tag with context
+func (w *WeChat) DeleteTagContext(ctx context.Context, id int) error {
+	return w.postJSONIdempotent(ctx, WeChatTagDelete, map[string]map[string]int{"tag": {"id": id}}, nil)
+}
+
+//Tag users, at most 50 users each time.
+func (w *WeChat) TagUsers(tagId int, openids []string) error {
+	return w.TagUsersContext(context.Background(), tagId, openids)
+}
+
+//Tag users with context
+func (w *WeChat) TagUsersContext(ctx context.Context, tagId int, openids []string) error {
+	return w.postJSONIdempotent(ctx, WeChatTagBatchTagging, map[string]interface{}{
+		"openid_list": openids,
+		"tagid":       tagId,
+	}, nil)
+}
+
+//Untag users, at most 50 users each time.
+func (w *WeChat) UntagUsers(tagId int, openids []string) error {
+	return w.UntagUsersContext(context.Background(), tagId, openids)
+}
+
+//Untag users with context
+func (w *WeChat) UntagUsersContext(ctx context.Context, tagId int, openids []string) error {
+	return w.postJSONIdempotent(ctx, WeChatTagBatchUntagging, map[string]interface{}{
+		"openid_list": openids,
+		"tagid":       tagId,
+	}, nil)
+}
+
+//Get tag ids of user
+func (w *WeChat) GetUserTags(openid string) ([]int, error) {
+	return w.GetUserTagsContext(context.Background(), openid)
+}
+
+//Get tag ids of user with context
+func (w *WeChat) GetUserTagsContext(ctx context.Context, openid string) ([]int, error) {
+	var res struct {
+		TagidList []int `json:"tagid_list"`
+	}
+	err := w.postJSONIdempotent(ctx, WeChatTagGetIdList, map[string]string{"openid": openid}, &res)
+	return res.TagidList, err
+}
+
+//Get users with tag, at most 10000 users each time.
+//Pass the returned next openid to get the next page, it is empty at the end.
+func (w *WeChat) GetTagUsers(tagId int, nextOpenid string) ([]string, string, error) {
+	return w.GetTagUsersContext(context.Background(), tagId, nextOpenid)
+}
+
+//Get users with tag with context
+func (w *WeChat) GetTagUsersContext(ctx context.Context, tagId int, nextOpenid string) ([]string, string, error) {
+	var a struct {
+		Count int
+		Data  map[string][]string
+		Next  string `json:"next_openid"`
+	}
+	err := w.postJSONIdempotent(ctx, WeChatTagUsers, map[string]interface{}{
+		"tagid":       tagId,
+		"next_openid": nextOpenid,
+	}, &a)
+	if err != nil {
 		return nil, "", err
 	}
+	if a.Count == 0 {
+		return nil, "", nil
+	}
 	return a.Data["openid"], a.Next, nil
-
 }
@@ -0,0 +1,139 @@
+package wechat
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound  = errors.New("wechat: user not found")
+	ErrNoUserStorage = errors.New("wechat: storage does not implement UserStorage")
+	ErrSyncInterval  = errors.New("wechat: interval of user sync must be positive")
+)
+
+//Storage of followers, mirrored from WeChat server by SyncUsers.
+type UserStorage interface {
+	SaveUser(u *User, synced time.Time) error // Insert or update follower, skipped if it was removed after synced
+	RemoveUser(openid string) error           // Remove unsubscribed follower
+	RemoveUsersBefore(synced time.Time) error // Remove followers which are not synced since synced
+	ReadUser(openid string) (*User, error)    // Read follower, ErrUserNotFound if it does not exist
+}
+
+//Follower stored by UserStorage
+//Removed follower is kept until the next full sync, so users fetched by
+//the sync before they unsubscribed are not saved again.
+type follower struct {
+	Openid  string `bson:"_id"`
+	User    *User
+	Synced  time.Time
+	Removed time.Time // Time of unsubscribe, zero for followers
+}
+
+//Keep followers in UserStorage current with subscribe and unsubscribe
+//events pushed to ServeHTTP. Storage must implement UserStorage.
+func WithUserSync() Option {
+	return func(w *WeChat) error {
+		if _, ok := w.atrw.(UserStorage); !ok {
+			return ErrNoUserStorage
+		}
+		w.userSync = true
+		return nil
+	}
+}
+
+//Read follower from UserStorage, without calling WeChat server.
+func (w *WeChat) ReadUser(openid string) (*User, error) {
+	us, ok := w.atrw.(UserStorage)
+	if !ok {
+		return nil, ErrNoUserStorage
+	}
+	return us.ReadUser(openid)
+}
+
+//Mirror all followers into UserStorage, followers who unsubscribed are removed.
+func (w *WeChat) SyncUsers(ctx context.Context) error {
+	us, ok := w.atrw.(UserStorage)
+	if !ok {
+		return ErrNoUserStorage
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	users, errs := w.StreamUsers(ctx, "", 0)
+	var err error
+	for u := range users {
+		if err != nil || u.Subscribe == 0 {
+			// Drain users after error; skip users unsubscribed while syncing
+			continue
+		}
+		if err = us.SaveUser(u, start); err != nil {
+			// Stop fetching the remaining users
+			cancel()
+		}
+	}
+	serr := <-errs
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return serr
+	}
+	return us.RemoveUsersBefore(start)
+}
+
+//Run SyncUsers now and then every interval in background, until ctx is done.
+//Errors of sync are logged.
+func (w *WeChat) StartUserSync(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrSyncInterval
+	}
+	if _, ok := w.atrw.(UserStorage); !ok {
+		return ErrNoUserStorage
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := w.SyncUsers(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Println(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+//Update follower of subscribe or unsubscribe event in background.
+func (wc *WeChat) syncUserEvent(r *Request) {
+	if !wc.userSync || r.MsgType != msgEvent {
+		return
+	}
+	us := wc.atrw.(UserStorage)
+	switch r.Event {
+	case EventSubscribe:
+		received := time.Now()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultAPITimeout)
+			defer cancel()
+			u, err := wc.GetUserContext(ctx, r.FromUserName, "")
+			if err == nil {
+				// Skipped if the user unsubscribed after this event
+				err = us.SaveUser(u, received)
+			}
+			if err != nil {
+				wc.logger.Println(err)
+			}
+		}()
+	case EventUnsubscribe:
+		go func() {
+			if err := us.RemoveUser(r.FromUserName); err != nil {
+				wc.logger.Println(err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,23 @@
+package wechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemStorageRemovedUser(t *testing.T) {
+	s := NewMemStorage("wxappid", "secret", "token")
+	start := time.Now()
+	s.SaveUser(&User{Openid: "u1"}, start)
+	s.RemoveUser("u1")
+	// Fetched by the sync before the user unsubscribed
+	s.SaveUser(&User{Openid: "u1"}, start)
+	if _, err := s.ReadUser("u1"); err != ErrUserNotFound {
+		t.Error(err)
+	}
+	// Subscribed again
+	s.SaveUser(&User{Openid: "u1"}, time.Now().Add(time.Second))
+	if _, err := s.ReadUser("u1"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,1065 @@
+package wechattest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leptonyu/wechat"
+)
+
+//Error codes returned by the fake server, same as WeChat server.
+const (
+	ErrCodeSystemBusy         = -1
+	ErrCodeInvalidCredential  = 40001
+	ErrCodeInvalidOpenid      = 40003
+	ErrCodeInvalidMediaType   = 40004
+	ErrCodeInvalidMediaId     = 40007
+	ErrCodeInvalidTemplateId  = 40037
+	ErrCodeInvalidGroupId     = 40050
+	ErrCodeInvalidTagId       = 45159
+	ErrCodeInvalidAppid       = 40013
+	ErrCodeInvalidAccessToken = 40014
+	ErrCodeAccessTokenExpired = 42001
+	ErrCodeEmptyMedia         = 44001
+	ErrCodeEmptyPostData      = 44002
+	ErrCodeEmptyNews          = 44003
+	ErrCodeOutOfResponseLimit = 45015
+	ErrCodeMenuNotExist       = 46003
+	ErrCodeDataFormat         = 47001
+	ErrCodeTooManyUsers       = 45035
+	ErrCodeInvalidMsgId       = 61504
+	ErrCodeInvalidIndustry    = 40102
+)
+
+//Fake WeChat API server running in process. It implements token, user,
+//menu, group, QR scene, custom-send, media, mass, template and tag endpoints with WeChat error codes.
+type Server struct {
+	*httptest.Server
+	AppId          string
+	Secret         string
+	UserPageSize   int // Openids of each user/get page, default is 10000
+	TokenExpiresIn int // Seconds before access token expires, default is 7200
+
+	mu          sync.Mutex
+	token       string
+	tokenExpire time.Time
+	prevToken   string // Replaced token, still valid for a while like WeChat server
+	prevExpire  time.Time
+	tokenCount  int
+	users       []*wechat.User
+	menu        *wechat.Menu
+	groups      []*wechat.Group
+	groupId     int
+	tags        []*wechat.Tag
+	tagId       int
+	qrCount     int
+	sent        []json.RawMessage
+	outOfWindow map[string]bool
+	failures    []int
+	media       map[string]*media
+	news        int
+	mass        []json.RawMessage
+	massStatus  map[int64]string
+	industry    [2]string
+	templates   []*wechat.Template
+	tplCount    int
+	tplSent     []*wechat.TemplateMessage
+}
+
+//Uploaded media
+type media struct {
+	Type        string
+	ContentType string
+	Data        []byte
+}
+
+//Start fake server of account with appid and secret.
+func NewServer(appid, secret string) *Server {
+	s := &Server{
+		AppId:       appid,
+		Secret:      secret,
+		outOfWindow: map[string]bool{},
+		media:       map[string]*media{},
+		massStatus:  map[int64]string{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/token", s.handleToken)
+	s.handle(mux, "/cgi-bin/user/info", s.handleUserInfo)
+	s.handle(mux, "/cgi-bin/user/get", s.handleUserGet)
+	s.handle(mux, "/cgi-bin/user/info/batchget", s.handleUserBatchGet)
+	s.handle(mux, "/cgi-bin/menu/create", s.handleMenuCreate)
+	s.handle(mux, "/cgi-bin/menu/get", s.handleMenuGet)
+	s.handle(mux, "/cgi-bin/menu/delete", s.handleMenuDelete)
+	s.handle(mux, "/cgi-bin/groups/create", s.handleGroupCreate)
+	s.handle(mux, "/cgi-bin/groups/get", s.handleGroupGet)
+	s.handle(mux, "/cgi-bin/groups/update", s.handleGroupUpdate)
+	s.handle(mux, "/cgi-bin/groups/delete", s.handleGroupDelete)
+	s.handle(mux, "/cgi-bin/groups/members/update", s.handleGroupMemberUpdate)
+	s.handle(mux, "/cgi-bin/groups/members/batchupdate", s.handleGroupMemberBatchUpdate)
+	s.handle(mux, "/cgi-bin/groups/getid", s.handleGroupGetId)
+	s.handle(mux, "/cgi-bin/tags/create", s.handleTagCreate)
+	s.handle(mux, "/cgi-bin/tags/get", s.handleTagGet)
+	s.handle(mux, "/cgi-bin/tags/update", s.handleTagUpdate)
+	s.handle(mux, "/cgi-bin/tags/delete", s.handleTagDelete)
+	s.handle(mux, "/cgi-bin/tags/members/batchtagging", s.handleTagging(true))
+	s.handle(mux, "/cgi-bin/tags/members/batchuntagging", s.handleTagging(false))
+	s.handle(mux, "/cgi-bin/tags/getidlist", s.handleTagGetIdList)
+	s.handle(mux, "/cgi-bin/user/tag/get", s.handleTagUsers)
+	s.handle(mux, "/cgi-bin/qrcode/create", s.handleQRCreate)
+	s.handle(mux, "/cgi-bin/message/custom/send", s.handleCustomSend)
+	s.handle(mux, "/cgi-bin/media/upload", s.handleMediaUpload)
+	s.handle(mux, "/cgi-bin/media/get", s.handleMediaGet)
+	mux.HandleFunc("/video/", s.handleVideo)
+	s.handle(mux, "/cgi-bin/media/uploadnews", s.handleUploadNews)
+	s.handle(mux, "/cgi-bin/message/mass/sendall", s.handleMassSend)
+	s.handle(mux, "/cgi-bin/message/mass/send", s.handleMassSend)
+	s.handle(mux, "/cgi-bin/message/mass/preview", s.handleMassPreview)
+	s.handle(mux, "/cgi-bin/message/mass/delete", s.handleMassDelete)
+	s.handle(mux, "/cgi-bin/message/mass/get", s.handleMassGet)
+	s.handle(mux, "/cgi-bin/template/api_set_industry", s.handleSetIndustry)
+	s.handle(mux, "/cgi-bin/template/get_industry", s.handleGetIndustry)
+	s.handle(mux, "/cgi-bin/template/api_add_template", s.handleAddTemplate)
+	s.handle(mux, "/cgi-bin/template/get_all_private_template", s.handleGetTemplates)
+	s.handle(mux, "/cgi-bin/template/del_private_template", s.handleDeleteTemplate)
+	s.handle(mux, "/cgi-bin/message/template/send", s.handleTemplateSend)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+//Base URL of API, pass it to wechat.WithAPIHost.
+func (s *Server) APIHost() string {
+	return s.URL + "/cgi-bin/"
+}
+
+//Create WeChat in memory which calls the fake server.
+func (s *Server) NewWeChat(token string, options ...wechat.Option) (*wechat.WeChat, error) {
+	options = append([]wechat.Option{
+		wechat.WithAPIHost(s.APIHost()),
+		wechat.WithHTTPClient(s.Client()),
+	}, options...)
+	return wechat.NewWeChatInMem(s.AppId, s.Secret, token, options...)
+}
+
+//Add follower of account.
+func (s *Server) AddUser(u wechat.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Subscribe = 1
+	s.users = append(s.users, &u)
+}
+
+//Remove follower, like the user unsubscribed.
+func (s *Server) RemoveUser(openid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, u := range s.users {
+		if u.Openid == openid {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return
+		}
+	}
+}
+
+//Expire current access token, the next call with it gets 42001.
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenExpire = time.Now().Add(-time.Second)
+	s.prevExpire = s.tokenExpire
+}
+
+//Count of access tokens issued.
+func (s *Server) TokenCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCount
+}
+
+//Bodies of custom-send requests, in order.
+func (s *Server) Sent() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.sent...)
+}
+
+//User has not interacted in 48 hours, custom-send to it gets 45015.
+func (s *Server) SetOutOfWindow(openid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outOfWindow[openid] = true
+}
+
+//Fail the next API calls with errcodes in order, such as ErrCodeSystemBusy.
+//Use 500 or other http status codes to fail with http status.
+func (s *Server) Fail(errcodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, errcodes...)
+}
+
+//Current menu, nil if it is not created.
+func (s *Server) Menu() *wechat.Menu {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.menu
+}
+
+//Content of uploaded media, nil if it does not exist.
+func (s *Server) Media(mediaId string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.media[mediaId]; ok {
+		return m.Data
+	}
+	return nil
+}
+
+//Bodies of mass send and preview requests, in order.
+func (s *Server) Mass() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.mass...)
+}
+
+//Template messages sent, in order.
+func (s *Server) TemplatesSent() []*wechat.TemplateMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*wechat.TemplateMessage(nil), s.tplSent...)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, &wechat.ErrWeChat{ErrCode: code, ErrMsg: msg})
+}
+
+func writeOK(w http.ResponseWriter) {
+	writeError(w, 0, "ok")
+}
+
+//Check injected failures, return true if request failed.
+func (s *Server) fail(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	if len(s.failures) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	code := s.failures[0]
+	s.failures = s.failures[1:]
+	s.mu.Unlock()
+	if code >= 500 && code < 600 {
+		http.Error(w, http.StatusText(code), code)
+	} else {
+		writeError(w, code, "injected failure")
+	}
+	return true
+}
+
+//Register handler which requires valid access token.
+func (s *Server) handle(mux *http.ServeMux, path string, h func(http.ResponseWriter, *http.Request)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if s.fail(w) {
+			return
+		}
+		token := r.URL.Query().Get("access_token")
+		s.mu.Lock()
+		valid, expire := s.token, s.tokenExpire
+		if token != "" && token == s.prevToken {
+			valid, expire = s.prevToken, s.prevExpire
+		}
+		s.mu.Unlock()
+		switch {
+		case token == "" || token != valid:
+			writeError(w, ErrCodeInvalidCredential, "invalid credential, access_token is invalid or not latest")
+		case time.Now().After(expire):
+			writeError(w, ErrCodeAccessTokenExpired, "access_token expired")
+		default:
+			h(w, r)
+		}
+	})
+}
+
+//Read JSON body of post request.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(data) == 0 {
+		writeError(w, ErrCodeEmptyPostData, "empty post data")
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		writeError(w, ErrCodeDataFormat, "data format error")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w) {
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("appid") != s.AppId {
+		writeError(w, ErrCodeInvalidAppid, "invalid appid")
+		return
+	}
+	if q.Get("secret") != s.Secret {
+		writeError(w, ErrCodeInvalidCredential, "invalid credential, secret is invalid")
+		return
+	}
+	s.mu.Lock()
+	s.tokenCount++
+	// Replaced token is valid for 5 minutes
+	s.prevToken, s.prevExpire = s.token, time.Now().Add(5*time.Minute)
+	if s.prevExpire.After(s.tokenExpire) {
+		s.prevExpire = s.tokenExpire
+	}
+	expiresIn := s.TokenExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 7200
+	}
+	s.token = fmt.Sprintf("token_%d_%d", s.tokenCount, time.Now().UnixNano())
+	s.tokenExpire = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	token := s.token
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"access_token": token, "expires_in": expiresIn})
+}
+
+func (s *Server) user(openid string) *wechat.User {
+	for _, u := range s.users {
+		if u.Openid == openid {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u := s.user(r.URL.Query().Get("openid"))
+	s.mu.Unlock()
+	if u == nil {
+		writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+		return
+	}
+	writeJSON(w, u)
+}
+
+//Page size of user/get
+const userPageSize = 10000
+
+//Max users of batchget
+const batchGetLimit = 100
+
+func (s *Server) handleUserGet(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next_openid")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := 0
+	if next != "" {
+		start = -1
+		for i, u := range s.users {
+			if u.Openid == next {
+				start = i + 1
+			}
+		}
+		if start < 0 {
+			writeError(w, ErrCodeInvalidOpenid, "invalid next openid")
+			return
+		}
+	}
+	size := s.UserPageSize
+	if size <= 0 {
+		size = userPageSize
+	}
+	ids := []string{}
+	for i := start; i < len(s.users) && len(ids) < size; i++ {
+		ids = append(ids, s.users[i].Openid)
+	}
+	res := map[string]interface{}{
+		"total": len(s.users),
+		"count": len(ids),
+	}
+	if len(ids) > 0 {
+		res["data"] = map[string][]string{"openid": ids}
+		res["next_openid"] = ids[len(ids)-1]
+	} else {
+		res["next_openid"] = ""
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) handleUserBatchGet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserList []struct {
+			Openid string `json:"openid"`
+		} `json:"user_list"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.UserList) > batchGetLimit {
+		writeError(w, ErrCodeTooManyUsers, "too many users")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []wechat.User{}
+	for _, item := range req.UserList {
+		if u := s.user(item.Openid); u != nil {
+			list = append(list, *u)
+		} else {
+			list = append(list, wechat.User{Openid: item.Openid})
+		}
+	}
+	writeJSON(w, map[string]interface{}{"user_info_list": list})
+}
+
+func (s *Server) handleMenuCreate(w http.ResponseWriter, r *http.Request) {
+	menu := &wechat.Menu{}
+	if !readJSON(w, r, menu) {
+		return
+	}
+	s.mu.Lock()
+	s.menu = menu
+	s.mu.Unlock()
+	writeOK(w)
+}
+
+func (s *Server) handleMenuGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	menu := s.menu
+	s.mu.Unlock()
+	if menu == nil {
+		writeError(w, ErrCodeMenuNotExist, "menu no exist")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"menu": menu})
+}
+
+func (s *Server) handleMenuDelete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.menu = nil
+	s.mu.Unlock()
+	writeOK(w)
+}
+
+func (s *Server) handleGroupCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Group wechat.Group `json:"group"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	s.groupId++
+	g := &wechat.Group{Id: 100 + s.groupId, Name: req.Group.Name}
+	s.groups = append(s.groups, g)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"group": map[string]interface{}{"id": g.Id, "name": g.Name}})
+}
+
+func (s *Server) handleGroupGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := []map[string]interface{}{}
+	for _, g := range s.groups {
+		count := 0
+		for _, u := range s.users {
+			if u.Groupid == g.Id {
+				count++
+			}
+		}
+		groups = append(groups, map[string]interface{}{"id": g.Id, "name": g.Name, "count": count})
+	}
+	writeJSON(w, map[string]interface{}{"groups": groups})
+}
+
+func (s *Server) group(id int) int {
+	for i, g := range s.groups {
+		if g.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleGroupUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Group wechat.Group `json:"group"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.group(req.Group.Id)
+	if i < 0 {
+		writeError(w, ErrCodeInvalidGroupId, "invalid group id")
+		return
+	}
+	s.groups[i].Name = req.Group.Name
+	writeOK(w)
+}
+
+func (s *Server) handleGroupDelete(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Group wechat.Group `json:"group"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.group(req.Group.Id)
+	if i < 0 {
+		writeError(w, ErrCodeInvalidGroupId, "invalid group id")
+		return
+	}
+	s.groups = append(s.groups[:i], s.groups[i+1:]...)
+	for _, u := range s.users {
+		if u.Groupid == req.Group.Id {
+			u.Groupid = 0
+		}
+	}
+	writeOK(w)
+}
+
+//Move users to group, all of them must exist.
+func (s *Server) moveUsers(w http.ResponseWriter, openids []string, groupId int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if groupId != 0 && s.group(groupId) < 0 {
+		writeError(w, ErrCodeInvalidGroupId, "invalid group id")
+		return
+	}
+	for _, id := range openids {
+		if s.user(id) == nil {
+			writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+			return
+		}
+	}
+	for _, id := range openids {
+		s.user(id).Groupid = groupId
+	}
+	writeOK(w)
+}
+
+func (s *Server) handleGroupMemberUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Openid    string `json:"openid"`
+		ToGroupId int    `json:"to_groupid"`
+	}
+	if readJSON(w, r, &req) {
+		s.moveUsers(w, []string{req.Openid}, req.ToGroupId)
+	}
+}
+
+func (s *Server) handleGroupMemberBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OpenidList []string `json:"openid_list"`
+		ToGroupId  int      `json:"to_groupid"`
+	}
+	if readJSON(w, r, &req) {
+		s.moveUsers(w, req.OpenidList, req.ToGroupId)
+	}
+}
+
+func (s *Server) handleGroupGetId(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Openid string `json:"openid"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	u := s.user(req.Openid)
+	s.mu.Unlock()
+	if u == nil {
+		writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+		return
+	}
+	writeJSON(w, map[string]int{"groupid": u.Groupid})
+}
+
+func (s *Server) handleQRCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ExpireSeconds int    `json:"expire_seconds"`
+		ActionName    string `json:"action_name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	s.qrCount++
+	ticket := "ticket_" + strconv.Itoa(s.qrCount)
+	s.mu.Unlock()
+	res := map[string]interface{}{"ticket": ticket}
+	if req.ActionName == "QR_SCENE" {
+		res["expire_seconds"] = req.ExpireSeconds
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) handleCustomSend(w http.ResponseWriter, r *http.Request) {
+	var msg json.RawMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+	var to struct {
+		ToUser string `json:"touser"`
+	}
+	json.Unmarshal(msg, &to)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user(to.ToUser) == nil {
+		writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+		return
+	}
+	if s.outOfWindow[to.ToUser] {
+		writeError(w, ErrCodeOutOfResponseLimit, "response out of time limit or subscription is canceled")
+		return
+	}
+	s.sent = append(s.sent, msg)
+	writeOK(w)
+}
+
+func (s *Server) handleMediaUpload(w http.ResponseWriter, r *http.Request) {
+	typ := r.URL.Query().Get("type")
+	if _, ok := wechat.MediaSizeLimit[typ]; !ok {
+		writeError(w, ErrCodeInvalidMediaType, "invalid media type")
+		return
+	}
+	file, header, err := r.FormFile("media")
+	if err != nil {
+		writeError(w, ErrCodeEmptyMedia, "empty media data")
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil || len(data) == 0 {
+		writeError(w, ErrCodeEmptyMedia, "empty media data")
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(header.Filename))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	s.mu.Lock()
+	id := fmt.Sprintf("media_%d", len(s.media)+1)
+	s.media[id] = &media{Type: typ, ContentType: contentType, Data: data}
+	s.mu.Unlock()
+	res := map[string]interface{}{"type": typ, "created_at": time.Now().Unix()}
+	if typ == wechat.MediaTypeThumb {
+		res["thumb_media_id"] = id
+	} else {
+		res["media_id"] = id
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) handleMediaGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	m, ok := s.media[r.URL.Query().Get("media_id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, ErrCodeInvalidMediaId, "invalid media_id")
+		return
+	}
+	if m.Type == wechat.MediaTypeVideo {
+		// Video is returned as url of it
+		writeJSON(w, map[string]string{"video_url": s.URL + "/video/" + r.URL.Query().Get("media_id")})
+		return
+	}
+	w.Header().Set("Content-Type", m.ContentType)
+	w.Write(m.Data)
+}
+
+//Download video by url, which needs no access token.
+func (s *Server) handleVideo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	m, ok := s.media[strings.TrimPrefix(r.URL.Path, "/video/")]
+	s.mu.Unlock()
+	if !ok || m.Type != wechat.MediaTypeVideo {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", m.ContentType)
+	w.Write(m.Data)
+}
+
+func (s *Server) handleUploadNews(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Articles []wechat.NewsArticle `json:"articles"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Articles) == 0 {
+		writeError(w, ErrCodeEmptyNews, "empty news data")
+		return
+	}
+	s.mu.Lock()
+	s.news++
+	id := fmt.Sprintf("news_%d", s.news)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"type": "news", "media_id": id, "created_at": time.Now().Unix()})
+}
+
+func (s *Server) handleMassSend(w http.ResponseWriter, r *http.Request) {
+	var msg json.RawMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+	s.mu.Lock()
+	s.mass = append(s.mass, msg)
+	id := int64(1000 + len(s.mass))
+	s.massStatus[id] = wechat.MassStatusSuccess
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"errcode": 0, "errmsg": "send job submission success", "msg_id": id, "msg_data_id": id * 10})
+}
+
+func (s *Server) handleMassPreview(w http.ResponseWriter, r *http.Request) {
+	var msg json.RawMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+	s.mu.Lock()
+	s.mass = append(s.mass, msg)
+	s.mu.Unlock()
+	writeOK(w)
+}
+
+func (s *Server) handleMassDelete(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MsgId int64 `json:"msg_id"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.massStatus[req.MsgId]; !ok {
+		writeError(w, ErrCodeInvalidMsgId, "invalid msg_id")
+		return
+	}
+	s.massStatus[req.MsgId] = wechat.MassStatusDelete
+	writeOK(w)
+}
+
+func (s *Server) handleMassGet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MsgId string `json:"msg_id"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	id, _ := strconv.ParseInt(req.MsgId, 10, 64)
+	s.mu.Lock()
+	status, ok := s.massStatus[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, ErrCodeInvalidMsgId, "invalid msg_id")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"msg_id": id, "msg_status": status})
+}
+
+func (s *Server) handleSetIndustry(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Id1 string `json:"industry_id1"`
+		Id2 string `json:"industry_id2"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Id1 == "" || req.Id2 == "" {
+		writeError(w, ErrCodeInvalidIndustry, "invalid industry id")
+		return
+	}
+	s.mu.Lock()
+	s.industry = [2]string{req.Id1, req.Id2}
+	s.mu.Unlock()
+	writeOK(w)
+}
+
+func (s *Server) handleGetIndustry(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	industry := s.industry
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{
+		"primary_industry":   wechat.Industry{FirstClass: "industry", SecondClass: industry[0]},
+		"secondary_industry": wechat.Industry{FirstClass: "industry", SecondClass: industry[1]},
+	})
+}
+
+func (s *Server) handleAddTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ShortId string `json:"template_id_short"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.ShortId == "" {
+		writeError(w, ErrCodeInvalidTemplateId, "invalid template_id")
+		return
+	}
+	s.mu.Lock()
+	s.tplCount++
+	t := &wechat.Template{
+		TemplateId: fmt.Sprintf("template_%d", s.tplCount),
+		Title:      req.ShortId,
+		Content:    "{{first.DATA}}\n{{remark.DATA}}",
+	}
+	s.templates = append(s.templates, t)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"errcode": 0, "errmsg": "ok", "template_id": t.TemplateId})
+}
+
+func (s *Server) handleGetTemplates(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*wechat.Template{}
+	list = append(list, s.templates...)
+	writeJSON(w, map[string]interface{}{"template_list": list})
+}
+
+func (s *Server) template(id string) int {
+	for i, t := range s.templates {
+		if t.TemplateId == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TemplateId string `json:"template_id"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.template(req.TemplateId)
+	if i < 0 {
+		writeError(w, ErrCodeInvalidTemplateId, "invalid template_id")
+		return
+	}
+	s.templates = append(s.templates[:i], s.templates[i+1:]...)
+	writeOK(w)
+}
+
+func (s *Server) handleTemplateSend(w http.ResponseWriter, r *http.Request) {
+	msg := &wechat.TemplateMessage{}
+	if !readJSON(w, r, msg) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.template(msg.TemplateId) < 0 {
+		writeError(w, ErrCodeInvalidTemplateId, "invalid template_id")
+		return
+	}
+	if s.user(msg.ToUser) == nil {
+		writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+		return
+	}
+	s.tplSent = append(s.tplSent, msg)
+	writeJSON(w, map[string]interface{}{"errcode": 0, "errmsg": "ok", "msgid": 2000 + len(s.tplSent)})
+}
+
+func (s *Server) tag(id int) int {
+	for i, t := range s.tags {
+		if t.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleTagCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tag wechat.Tag `json:"tag"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	s.tagId++
+	t := &wechat.Tag{Id: 100 + s.tagId, Name: req.Tag.Name}
+	s.tags = append(s.tags, t)
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"tag": t})
+}
+
+func (s *Server) handleTagGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := []wechat.Tag{}
+	for _, t := range s.tags {
+		tag := *t
+		for _, u := range s.users {
+			if hasTag(u, t.Id) {
+				tag.Count++
+			}
+		}
+		tags = append(tags, tag)
+	}
+	writeJSON(w, map[string]interface{}{"tags": tags})
+}
+
+func (s *Server) handleTagUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tag wechat.Tag `json:"tag"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.tag(req.Tag.Id)
+	if i < 0 {
+		writeError(w, ErrCodeInvalidTagId, "invalid tag id")
+		return
+	}
+	s.tags[i].Name = req.Tag.Name
+	writeOK(w)
+}
+
+func (s *Server) handleTagDelete(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tag wechat.Tag `json:"tag"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.tag(req.Tag.Id)
+	if i < 0 {
+		writeError(w, ErrCodeInvalidTagId, "invalid tag id")
+		return
+	}
+	s.tags = append(s.tags[:i], s.tags[i+1:]...)
+	for _, u := range s.users {
+		untag(u, req.Tag.Id)
+	}
+	writeOK(w)
+}
+
+func hasTag(u *wechat.User, id int) bool {
+	for _, t := range u.TagidList {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
+
+func untag(u *wechat.User, id int) {
+	list := []int{}
+	for _, t := range u.TagidList {
+		if t != id {
+			list = append(list, t)
+		}
+	}
+	u.TagidList = list
+}
+
+func (s *Server) handleTagging(tagging bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			OpenidList []string `json:"openid_list"`
+			TagId      int      `json:"tagid"`
+		}
+		if !readJSON(w, r, &req) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.tag(req.TagId) < 0 {
+			writeError(w, ErrCodeInvalidTagId, "invalid tag id")
+			return
+		}
+		for _, id := range req.OpenidList {
+			if s.user(id) == nil {
+				writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+				return
+			}
+		}
+		for _, id := range req.OpenidList {
+			u := s.user(id)
+			untag(u, req.TagId)
+			if tagging {
+				u.TagidList = append(u.TagidList, req.TagId)
+			}
+		}
+		writeOK(w)
+	}
+}
+
+func (s *Server) handleTagGetIdList(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Openid string `json:"openid"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(req.Openid)
+	if u == nil {
+		writeError(w, ErrCodeInvalidOpenid, "invalid openid")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"tagid_list": append([]int{}, u.TagidList...)})
+}
+
+func (s *Server) handleTagUsers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TagId int    `json:"tagid"`
+		Next  string `json:"next_openid"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tag(req.TagId) < 0 {
+		writeError(w, ErrCodeInvalidTagId, "invalid tag id")
+		return
+	}
+	ids := []string{}
+	started := req.Next == ""
+	for _, u := range s.users {
+		if len(ids) >= userPageSize {
+			break
+		}
+		if started && hasTag(u, req.TagId) {
+			ids = append(ids, u.Openid)
+		}
+		if u.Openid == req.Next {
+			started = true
+		}
+	}
+	res := map[string]interface{}{"count": len(ids), "next_openid": ""}
+	if len(ids) > 0 {
+		res["data"] = map[string][]string{"openid": ids}
+		res["next_openid"] = ids[len(ids)-1]
+	}
+	writeJSON(w, res)
+}
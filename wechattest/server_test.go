@@ -0,0 +1,81 @@
+package wechattest
+
+import (
+	"testing"
+
+	"github.com/leptonyu/wechat"
+)
+
+func errCode(err error) int {
+	if e, ok := err.(*wechat.ErrWeChat); ok {
+		return e.ErrCode
+	}
+	return 0
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer("wxappid", "secret")
+	defer s.Close()
+	s.AddUser(wechat.User{Openid: "u1", Nickname: "Alice"})
+	s.AddUser(wechat.User{Openid: "u2", Nickname: "Bob"})
+	wc, err := s.NewWeChat("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := wc.GetUser("u1", "")
+	if err != nil || u.Nickname != "Alice" || u.Subscribe != 1 {
+		t.Error(u, err)
+	}
+	if _, err := wc.GetUser("nobody", ""); errCode(err) != ErrCodeInvalidOpenid {
+		t.Error(err)
+	}
+	ids, next, err := wc.GetAllUser("")
+	if err != nil || len(ids) != 2 || next != "u2" {
+		t.Error(ids, next, err)
+	}
+
+	if _, err := wc.GetMenu(); errCode(err) != ErrCodeMenuNotExist {
+		t.Error(err)
+	}
+	menu := &wechat.Menu{Buttons: []wechat.MenuButton{{Name: "今日歌曲", Type: wechat.MenuButtonTypeKey, Key: "V1001"}}}
+	if err := wc.CreateMenu(menu); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := wc.GetMenu(); err != nil || len(m.Buttons) != 1 || m.Buttons[0].Key != "V1001" {
+		t.Error(m, err)
+	}
+	if err := wc.DeleteMenu(); err != nil || s.Menu() != nil {
+		t.Error(err)
+	}
+
+	if g, err := wc.CreateGroup("vip"); err != nil || g.Name != "vip" || g.Id == 0 {
+		t.Error(g, err)
+	}
+	if qr, err := wc.CreateQRScene(1, 1800); err != nil || qr.Ticket == "" || qr.ExpireSeconds != 1800 {
+		t.Error(qr, err)
+	}
+
+	if err := wc.PostText("u1", "hello"); err != nil || len(s.Sent()) != 1 {
+		t.Error(err, s.Sent())
+	}
+	s.SetOutOfWindow("u2")
+	if err := wc.PostText("u2", "hello"); errCode(err) != ErrCodeOutOfResponseLimit {
+		t.Error(err)
+	}
+	if s.TokenCount() != 1 {
+		t.Error("token fetched", s.TokenCount(), "times")
+	}
+}
+
+func TestServerInvalidSecret(t *testing.T) {
+	s := NewServer("wxappid", "secret")
+	defer s.Close()
+	wc, err := wechat.NewWeChatInMem("wxappid", "wrong", "token", wechat.WithAPIHost(s.APIHost()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.GetMenu(); errCode(err) != ErrCodeInvalidCredential {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Package wechattest builds signed (and optionally encrypted) WeChat callbacks,
+sends them to a handler such as *wechat.WeChat through httptest, and parses
+the passive reply back into typed structs.
+*/
+package wechattest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leptonyu/wechat"
+)
+
+//Default users of callbacks
+const (
+	DefaultToUserName   = "gh_test"   // Original id of official account
+	DefaultFromUserName = "test_user" // Openid of user
+)
+
+//Fake WeChat server which sends callbacks to handler.
+type Client struct {
+	Handler        http.Handler // Handler of callbacks, usually *wechat.WeChat
+	Token          string       // Token of account
+	AppId          string       // Appid of account, used by encrypted callbacks
+	EncodingAESKey string       // Send encrypted callbacks if it is not empty
+	Path           string       // URL path of callbacks, default is "/"
+	ToUserName     string       // Original id of account, default is DefaultToUserName
+	FromUserName   string       // Openid of user, default is DefaultFromUserName
+
+	mu         sync.Mutex
+	msgId      int64
+	createTime int64
+}
+
+//Create client sending plaintext callbacks.
+func NewClient(handler http.Handler, token string) *Client {
+	return &Client{Handler: handler, Token: token}
+}
+
+//Create client sending encrypted callbacks.
+func NewEncryptedClient(handler http.Handler, token, appid, encodingAESKey string) *Client {
+	return &Client{Handler: handler, Token: token, AppId: appid, EncodingAESKey: encodingAESKey}
+}
+
+//Passive reply of handler
+type Reply struct {
+	ToUserName   string
+	FromUserName string
+	CreateTime   int64
+	MsgType      string
+	Content      string
+	Image        Media
+	Voice        Media
+	Video        Video
+	Music        wechat.Music
+	ArticleCount int
+	Articles     []wechat.Article `xml:"Articles>item"`
+	TransInfo    struct {
+		KfAccount string
+	}
+	Raw []byte `xml:"-"` // Body of reply, decrypted if it is encrypted
+}
+
+type Media struct {
+	MediaId string
+}
+
+type Video struct {
+	MediaId     string
+	Title       string
+	Description string
+}
+
+//Handler did not reply, the body is empty or "success".
+func (r *Reply) Empty() bool {
+	return len(r.Raw) == 0 || string(r.Raw) == "success"
+}
+
+//Status of callback is not 200.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("wechattest: status %d: %s", e.Code, e.Body)
+}
+
+//Envelope of encrypted message
+type envelope struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:",omitempty"`
+	Encrypt      string
+	MsgSignature string `xml:",omitempty"`
+	TimeStamp    string `xml:",omitempty"`
+	Nonce        string `xml:",omitempty"`
+}
+
+//Callback message, the root element of WeChat callbacks is <xml>.
+type message struct {
+	XMLName xml.Name `xml:"xml"`
+	*wechat.Request
+}
+
+//Send request to handler. Empty ToUserName, FromUserName and CreateTime
+//are filled, and a unique MsgId is set for non event messages.
+func (c *Client) Send(req *wechat.Request) (*Reply, error) {
+	if req.ToUserName == "" {
+		req.ToUserName = c.ToUserName
+		if req.ToUserName == "" {
+			req.ToUserName = DefaultToUserName
+		}
+	}
+	if req.FromUserName == "" {
+		req.FromUserName = c.FromUserName
+		if req.FromUserName == "" {
+			req.FromUserName = DefaultFromUserName
+		}
+	}
+	c.mu.Lock()
+	if req.CreateTime == 0 {
+		// Events are deduplicated by FromUserName and CreateTime
+		c.createTime++
+		if now := time.Now().Unix(); c.createTime < now {
+			c.createTime = now
+		}
+		req.CreateTime = int(c.createTime)
+	}
+	if req.MsgType != "event" && req.MsgId == 0 {
+		c.msgId++
+		req.MsgId = time.Now().UnixNano() + c.msgId
+	}
+	c.mu.Unlock()
+	data, err := xml.Marshal(&message{Request: req})
+	if err != nil {
+		return nil, err
+	}
+	return c.SendRaw(data)
+}
+
+//Send raw XML message to handler.
+func (c *Client) SendRaw(data []byte) (*Reply, error) {
+	q, crypt, err := c.query()
+	if err != nil {
+		return nil, err
+	}
+	if crypt != nil {
+		var to struct {
+			ToUserName string
+		}
+		if err := xml.Unmarshal(data, &to); err != nil {
+			return nil, err
+		}
+		enc, err := crypt.Encrypt(data)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("encrypt_type", "aes")
+		q.Set("msg_signature", wechat.Signature(c.Token, q.Get("timestamp"), q.Get("nonce"), enc))
+		if data, err = xml.Marshal(&envelope{ToUserName: to.ToUserName, Encrypt: enc}); err != nil {
+			return nil, err
+		}
+	}
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, httptest.NewRequest("POST", c.path()+"?"+q.Encode(), strings.NewReader(string(data))))
+	if rec.Code != http.StatusOK {
+		return nil, &StatusError{Code: rec.Code, Body: rec.Body.String()}
+	}
+	return c.parseReply(rec.Body.Bytes(), crypt)
+}
+
+//Send the GET request used by WeChat to verify the URL, return the echo.
+func (c *Client) Verify(echostr string) (string, error) {
+	q, _, err := c.query()
+	if err != nil {
+		return "", err
+	}
+	q.Set("echostr", echostr)
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, httptest.NewRequest("GET", c.path()+"?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		return "", &StatusError{Code: rec.Code, Body: rec.Body.String()}
+	}
+	return rec.Body.String(), nil
+}
+
+func (c *Client) path() string {
+	if c.Path == "" {
+		return "/"
+	}
+	return c.Path
+}
+
+//Signed query of callback
+func (c *Client) query() (url.Values, *wechat.MsgCrypt, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	c.mu.Lock()
+	c.msgId++
+	nonce := strconv.FormatInt(time.Now().UnixNano()+c.msgId, 36)
+	c.mu.Unlock()
+	q := url.Values{
+		"timestamp": {timestamp},
+		"nonce":     {nonce},
+		"signature": {wechat.Signature(c.Token, timestamp, nonce)},
+	}
+	if c.EncodingAESKey == "" {
+		return q, nil, nil
+	}
+	crypt, err := wechat.NewMsgCrypt(c.EncodingAESKey, c.AppId)
+	return q, crypt, err
+}
+
+//Parse reply, verify and decrypt it if it is encrypted.
+func (c *Client) parseReply(data []byte, crypt *wechat.MsgCrypt) (*Reply, error) {
+	reply := &Reply{Raw: data}
+	if len(data) == 0 || string(data) == "success" {
+		return reply, nil
+	}
+	if crypt != nil {
+		env := &envelope{}
+		if err := xml.Unmarshal(data, env); err != nil {
+			return nil, err
+		}
+		if wechat.Signature(c.Token, env.TimeStamp, env.Nonce, env.Encrypt) != env.MsgSignature {
+			return nil, fmt.Errorf("wechattest: invalid reply signature")
+		}
+		plain, err := crypt.Decrypt(env.Encrypt)
+		if err != nil {
+			return nil, err
+		}
+		reply.Raw = plain
+	}
+	if err := xml.Unmarshal(reply.Raw, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+//Send text message.
+func (c *Client) Text(content string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeText, Content: content})
+}
+
+//Send image message.
+func (c *Client) Image(picUrl, mediaId string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeImage, PicUrl: picUrl, MediaId: mediaId})
+}
+
+//Send voice message, recognition is the result of speech recognition.
+func (c *Client) Voice(mediaId, format, recognition string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeVoice, MediaId: mediaId, Format: format, Recognition: recognition})
+}
+
+//Send video message.
+func (c *Client) Video(mediaId, thumbMediaId string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgVideo, MediaId: mediaId, ThumbMediaId: thumbMediaId})
+}
+
+//Send shortvideo message.
+func (c *Client) ShortVideo(mediaId, thumbMediaId string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeShortVideo, MediaId: mediaId, ThumbMediaId: thumbMediaId})
+}
+
+//Send location message.
+func (c *Client) Location(x, y, scale float32, label string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeLocation, LocationX: x, LocationY: y, Scale: scale, Label: label})
+}
+
+//Send link message.
+func (c *Client) Link(title, description, url string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: wechat.MsgTypeLink, Title: title, Description: description, Url: url})
+}
+
+//Send event, such as wechat.EventSubscribe or wechat.EventClick.
+func (c *Client) Event(event, eventKey string) (*Reply, error) {
+	return c.Send(&wechat.Request{MsgType: "event", Event: event, EventKey: eventKey})
+}
@@ -0,0 +1,71 @@
+package wechattest
+
+import (
+	"testing"
+
+	"github.com/leptonyu/wechat"
+)
+
+const testAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+
+func newWeChat(t *testing.T, options ...wechat.Option) *wechat.WeChat {
+	wc, err := wechat.New(&wechat.MemStorage{}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.RegisterHandler(func(w wechat.RespondWriter, r *wechat.Request) error {
+		w.ReplyText("echo:" + r.Content)
+		return nil
+	}, wechat.MsgTypeText)
+	wc.RegisterHandler(func(w wechat.RespondWriter, r *wechat.Request) error {
+		w.ReplyNews([]wechat.Article{{Title: "welcome", Url: "http://example.com"}})
+		return nil
+	}, wechat.MsgTypeEventSubscribe)
+	wc.RegisterHandler(func(w wechat.RespondWriter, r *wechat.Request) error {
+		w.ReplyImage(r.MediaId)
+		return nil
+	}, wechat.MsgTypeImage)
+	return wc
+}
+
+func TestClient(t *testing.T) {
+	c := NewClient(newWeChat(t), "")
+	reply, err := c.Text("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.MsgType != "text" || reply.Content != "echo:hi" || reply.ToUserName != DefaultFromUserName {
+		t.Error(reply)
+	}
+	if reply, err = c.Event(wechat.EventSubscribe, ""); err != nil || len(reply.Articles) != 1 || reply.Articles[0].Title != "welcome" {
+		t.Error(reply, err)
+	}
+	if reply, err = c.Image("http://example.com/a.jpg", "media"); err != nil || reply.Image.MediaId != "media" {
+		t.Error(reply, err)
+	}
+	if reply, err = c.Event(wechat.EventClick, "KEY"); err != nil || !reply.Empty() {
+		t.Error(reply, err)
+	}
+	if echo, err := c.Verify("echo"); err != nil || echo != "echo" {
+		t.Error(echo, err)
+	}
+	c.Token = "wrong"
+	if _, err := c.Text("hi"); err == nil || err.(*StatusError).Code != 401 {
+		t.Error(err)
+	}
+}
+
+func TestEncryptedClient(t *testing.T) {
+	wc := newWeChat(t, wechat.WithEncodingAESKey(testAESKey, wechat.EncodingSafe))
+	c := NewEncryptedClient(wc, "", "", testAESKey)
+	reply, err := c.Text("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "echo:secret" {
+		t.Error(string(reply.Raw))
+	}
+	if _, err := NewClient(wc, "").Text("plain"); err == nil {
+		t.Error("plaintext callback accepted in safe mode")
+	}
+}